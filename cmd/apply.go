@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/aschmidt75/ipvsctl/ipvs"
 	cli "github.com/jawher/mow.cli"
 )
 
@@ -18,6 +19,8 @@ func parseAllowedActions(actionSpec *string) (integration.ApplyActions, error) {
 		integration.ApplyActionAddDestination:    true,
 		integration.ApplyActionUpdateDestination: true,
 		integration.ApplyActionDeleteDestination: true,
+		integration.ApplyActionSyncDaemon:        true,
+		integration.ApplyActionUpdateTimeouts:    true,
 	}
 	if actionSpec != nil {
 		if *actionSpec == "*" {
@@ -48,7 +51,8 @@ func Apply(cmd *cli.Cmd) {
 		actionSpec  = cmd.StringOpt("allowed-actions", "*", `
 Comma-separated list of allowed actions.
 as=Add service, us=update service, ds=delete service,
-ad=Add destination, ud=update destination, dd=delete destination.
+ad=Add destination, ud=update destination, dd=delete destination,
+sy=start/restart/stop sync daemons, ut=update timeouts.
 Default * for all actions.
 `)
 	)
@@ -86,13 +90,28 @@ Default * for all actions.
 			os.Exit(exitInvalidInput)
 		}
 
+		// query current config, this loads the kernel module if necessary
+		currentConfig := MustGetCurrentConfig()
+
+		// validate model against capabilities of the running kernel
+		err = resolvedConfig.WithCapabilities(ipvs.Probe()).Validate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error validation model: %s\n", err)
+			os.Exit(exitValidateErr)
+		}
+
 		// apply new configuration
-		err = MustGetCurrentConfig().Apply(resolvedConfig, integration.ApplyOpts{
+		ctx, cancel := newContext()
+		defer cancel()
+		err = currentConfig.ApplyContext(ctx, resolvedConfig.Normalize(), integration.ApplyOpts{
 			KeepWeights:    *keepWeights,
 			AllowedActions: allowedSet,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error applying updates: %s\n", err)
+			if isTimeout(err) {
+				os.Exit(exitIpvsErrTimeout)
+			}
 			os.Exit(exitApplyErr)
 		}
 		fmt.Printf("Applied configuration from %s\n", *applyFile)
@@ -19,6 +19,8 @@ func TestAllowedActions(t *testing.T) {
 			integration.ApplyActionAddDestination:    true,
 			integration.ApplyActionUpdateDestination: true,
 			integration.ApplyActionDeleteDestination: true,
+			integration.ApplyActionSyncDaemon:        true,
+			integration.ApplyActionUpdateTimeouts:    true,
 		}},
 	}
 
@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"gopkg.in/yaml.v2"
-
 	integration "github.com/aschmidt75/ipvsctl/integration"
 	cli "github.com/jawher/mow.cli"
 )
@@ -18,6 +16,7 @@ func ChangeSet(cmd *cli.Cmd) {
 	)
 
 	cmd.Action = func() {
+		checkOutputFormat("changeset")
 
 		if *csFile == "" {
 			fmt.Fprintf(os.Stderr, "Must specify an input file or - for stdin\n")
@@ -45,17 +44,15 @@ func ChangeSet(cmd *cli.Cmd) {
 		}
 
 		// create changeset from new configuration
-		cs, err := MustGetCurrentConfig().ChangeSet(resolvedConfig, integration.ApplyOpts{})
+		cs, err := MustGetCurrentConfig().ChangeSet(resolvedConfig.Normalize(), integration.ApplyOpts{})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error building/applying changeset: %s\n", err)
 			os.Exit(exitApplyErr)
 		}
 
-		b, err := yaml.Marshal(cs)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "unable to format as yaml")
+		if err := writeOutput(os.Stdout, cs); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
 			os.Exit(exitErrOutput)
 		}
-		fmt.Printf("%s", string(b))
 	}
 }
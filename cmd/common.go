@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"encoding/json"
 
@@ -15,6 +20,7 @@ import (
 	dynp "github.com/aschmidt75/go-dynamic-params"
 	"github.com/aschmidt75/ipvsctl/config"
 	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/aschmidt75/ipvsctl/ipvs"
 )
 
 func readInput(filename *string) ([]byte, error) {
@@ -45,15 +51,69 @@ func readModelFromInput(filename *string) (*integration.IPVSConfig, error) {
 		return nil, err
 	}
 
-	err = yaml.Unmarshal(b, c)
+	err = c.UnmarshalStrict(b)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing yaml from %s\n", *filename)
+		format := "yaml"
+		if isJSON(b) {
+			format = "json"
+		}
+		fmt.Fprintf(os.Stderr, "Error parsing %s from %s: %s\n", format, *filename, err)
 		os.Exit(exitInvalidFile)
 	}
 
 	return c, err
 }
 
+// isJSON returns true if b looks like a json document, i.e. an object
+func isJSON(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	return len(b) > 0 && b[0] == '{'
+}
+
+// checkOutputFormat exits if the global --output option is ipvsadm, which only
+// commands writing models support. It is called before any other work is done.
+func checkOutputFormat(command string) {
+	if config.Config().Output == "ipvsadm" {
+		fmt.Fprintf(os.Stderr, "Output format ipvsadm is not supported by %s, only by get, import and convert\n", command)
+		os.Exit(exitInvalidInput)
+	}
+}
+
+// writeOutput writes v in the format given by the global --output option.
+// The ipvsadm format is only available for models.
+func writeOutput(w io.Writer, v interface{}) error {
+	switch config.Config().Output {
+	case "json":
+		return writeJSON(w, v)
+	case "ipvsadm":
+		c, ok := v.(*integration.IPVSConfig)
+		if !ok {
+			return errors.New("output format ipvsadm is only supported for models, e.g. by get")
+		}
+		return c.WriteIpvsadmRules(w)
+	default:
+		return writeYAML(w, v)
+	}
+}
+
+func writeYAML(w io.Writer, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
 func mustAddResolverFromDataOrDie(origin string, rc dynp.ResolverChain, data []byte) dynp.ResolverChain {
 	// determine type
 	var f interface{}
@@ -184,16 +244,45 @@ func resolveParams(ipvsconfig *integration.IPVSConfig) (*integration.IPVSConfig,
 	return res, err
 }
 
+// newContext returns a context for a single ipvs operation, which is
+// limited by the --timeout option if given
+func newContext() (context.Context, context.CancelFunc) {
+	if timeout := config.Config().Timeout; timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// newContextFor is like newContext, but extends the --timeout by d for
+// operations which take d on their own, e.g. set weight --time
+func newContextFor(d time.Duration) (context.Context, context.CancelFunc) {
+	if timeout := config.Config().Timeout; timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout+d)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// isTimeout returns true if err was caused by an exceeded --timeout
+func isTimeout(err error) bool {
+	var timeoutErr *ipvs.TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
 // MustGetCurrentConfig queries the current IPVS configuration
 // or exits in case of an error.
 func MustGetCurrentConfig() *integration.IPVSConfig {
 	l := config.Config().Logger()
 	// retrieve current config
-	currentConfig := integration.NewIPVSConfigWithLogger(l)
-	err := currentConfig.Get()
+	currentConfig := integration.NewIPVSConfigWithLogger(l).WithNamespace(config.Config().Netns)
+	ctx, cancel := newContext()
+	defer cancel()
+	err := currentConfig.GetContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to get current ipvs config: %s", err)
 
+		if isTimeout(err) {
+			os.Exit(exitIpvsErrTimeout)
+		}
 		if _, ok := err.(*integration.IPVSHandleError); ok {
 			os.Exit(exitIpvsErrHandle)
 		}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsJSON(t *testing.T) {
+	var tests = []struct {
+		inp string
+		res bool
+	}{
+		{`{"services":[]}`, true},
+		{"\n  {\n}", true},
+		{"services:\n- address: tcp://10.0.0.1:80\n", false},
+		{"---\n{}", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.res, isJSON([]byte(test.inp)), test.inp)
+	}
+}
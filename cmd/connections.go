@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aschmidt75/ipvsctl/config"
+	"github.com/aschmidt75/ipvsctl/integration"
+	cli "github.com/jawher/mow.cli"
+)
+
+// Connections implements the "connections" cli command
+func Connections(cmd *cli.Cmd) {
+	cmd.Spec = "[--service=<SERVICE>] [--destination=<DESTINATION>] [--client=<CIDR>] [--state=<STATE>] [--format=<FORMAT>] [--summary]"
+	var (
+		service     = cmd.StringOpt("s service", "", "Handle of service, e.g. tcp://127.0.0.1:80")
+		destination = cmd.StringOpt("d destination", "", "Address of destination, e.g. 10.0.0.1:80")
+		client      = cmd.StringOpt("c client", "", "Client address or CIDR, e.g. 192.168.0.0/16")
+		state       = cmd.StringOpt("state", "", "Connection state, e.g. ESTABLISHED")
+		format      = cmd.StringOpt("format", "", "Output format, one of table, yaml or json. Default: global output format or table")
+		summary     = cmd.BoolOpt("summary", false, "Only show connection counts per destination and per state")
+	)
+
+	cmd.Action = func() {
+		if *format == "" {
+			checkOutputFormat("connections")
+			*format = config.Config().Output
+		}
+		if *format == "" {
+			*format = "table"
+		}
+		if *format != "table" && *format != "yaml" && *format != "json" {
+			fmt.Fprintf(os.Stderr, "Invalid format %s. Must be one of table, yaml or json\n", *format)
+			os.Exit(exitInvalidInput)
+		}
+
+		l := config.Config().Logger()
+		conns, err := integration.NewIPVSConfigWithLogger(l).WithNamespace(config.Config().Netns).Connections(integration.ConnectionFilter{
+			Service:     *service,
+			Destination: *destination,
+			Client:      *client,
+			State:       *state,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitConnectionsErr)
+		}
+
+		var out interface{} = conns
+		if *summary {
+			out = integration.SummarizeConnections(conns)
+		}
+
+		switch *format {
+		case "yaml":
+			err = writeYAML(os.Stdout, out)
+		case "json":
+			err = writeJSON(os.Stdout, out)
+		default:
+			if *summary {
+				writeConnectionSummaryTable(os.Stdout, out.(*integration.ConnectionSummary))
+			} else {
+				writeConnectionsTable(os.Stdout, conns)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
+			os.Exit(exitErrOutput)
+		}
+	}
+}
+
+func writeConnectionsTable(w io.Writer, conns []*integration.Connection) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROTO\tCLIENT\tSERVICE\tDESTINATION\tSTATE\tEXPIRES\tPE\tTEMPLATE")
+	for _, c := range conns {
+		template := ""
+		if c.Template {
+			template = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", c.Protocol, c.Client, c.Service, c.Destination, c.State, c.Expires, c.PEName, template)
+	}
+	tw.Flush()
+}
+
+func writeConnectionSummaryTable(w io.Writer, s *integration.ConnectionSummary) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINATION\tCONNECTIONS")
+	for _, k := range integration.SortedKeys(s.PerDestination) {
+		fmt.Fprintf(tw, "%s\t%d\n", k, s.PerDestination[k])
+	}
+	fmt.Fprintln(tw, "\t")
+	fmt.Fprintln(tw, "STATE\tCONNECTIONS")
+	for _, k := range integration.SortedKeys(s.PerState) {
+		fmt.Fprintf(tw, "%s\t%d\n", k, s.PerState[k])
+	}
+	fmt.Fprintln(tw, "\t")
+	fmt.Fprintf(tw, "TOTAL\t%d\n", s.Total)
+	if s.Templates > 0 {
+		fmt.Fprintf(tw, "TEMPLATES\t%d\n", s.Templates)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteConnectionsTable(t *testing.T) {
+	conns, err := integration.ParseConnections(strings.NewReader(
+		"TCP C0A80001 D7AA 0A000001 01BB 0A010001 01BB ESTABLISHED     899\n" +
+			"TCP C0A80001 0000 0A000001 01BB 0A010001 01BB NONE            299\n"))
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	writeConnectionsTable(&out, conns)
+
+	// empty trailing cells are padded
+	var lines []string
+	for _, line := range strings.SplitAfter(out.String(), "\n") {
+		lines = append(lines, strings.TrimRight(line, " \n"))
+	}
+	assert.Equal(t, `PROTO  CLIENT             SERVICE             DESTINATION   STATE        EXPIRES  PE  TEMPLATE
+tcp    192.168.0.1:55210  tcp://10.0.0.1:443  10.1.0.1:443  ESTABLISHED  899
+tcp    192.168.0.1        tcp://10.0.0.1:443  10.1.0.1:443  NONE         299          yes
+`, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	cli "github.com/jawher/mow.cli"
+)
+
+// Convert implements the "convert" cli command
+func Convert(cmd *cli.Cmd) {
+	cmd.Spec = "(--from=<FORMAT> | --to=<FORMAT>) [-f=<FILENAME>]"
+	var (
+		from     = cmd.StringOpt("from", "", "Format of the configuration to convert into a model, keepalived for virtual_server blocks of keepalived.conf")
+		to       = cmd.StringOpt("to", "", "Format to convert a model into, keepalived for virtual_server blocks of keepalived.conf")
+		filename = cmd.StringOpt("f", "-", "File to convert. Use - for STDIN")
+	)
+
+	cmd.Action = func() {
+		format := *from
+		if format == "" {
+			format = *to
+		}
+		if format != "keepalived" {
+			fmt.Fprintf(os.Stderr, "Invalid format %s. Must be keepalived\n", format)
+			os.Exit(exitInvalidInput)
+		}
+
+		var unmapped []integration.UnmappedConstruct
+		if *from != "" {
+			b, _ := readInput(filename)
+
+			c, u, err := integration.ParseKeepalivedConfig(bytes.NewReader(b))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(exitConvertErr)
+			}
+			unmapped = u
+
+			if err := writeOutput(os.Stdout, c); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
+				os.Exit(exitErrOutput)
+			}
+		} else {
+			c, err := readModelFromInput(filename)
+			if err != nil {
+				os.Exit(exitInvalidFile)
+			}
+
+			cr, err := resolveParams(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to resolve params: %s\n", err)
+				os.Exit(exitParamErr)
+			}
+
+			unmapped, err = cr.Normalize().WriteKeepalivedConfig(os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(exitConvertErr)
+			}
+		}
+
+		for _, u := range unmapped {
+			fmt.Fprintf(os.Stderr, "Unmapped: %s\n", u)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aschmidt75/ipvsctl/ipvs"
+	cli "github.com/jawher/mow.cli"
+)
+
+// doctorReport is the output of the doctor command
+type doctorReport struct {
+	ipvs.Capabilities `yaml:",inline"`
+	Hints             []string `yaml:"hints,omitempty" json:"hints,omitempty"`
+}
+
+// Doctor implements the "doctor" cli command
+func Doctor(cmd *cli.Cmd) {
+	cmd.Spec = "[--hints]"
+	var (
+		hints = cmd.BoolOpt("hints", false, "Show hints on how to fix missing capabilities")
+	)
+
+	cmd.Action = func() {
+		checkOutputFormat("doctor")
+
+		caps := ipvs.Probe()
+
+		report := doctorReport{Capabilities: *caps}
+		if *hints {
+			report.Hints = caps.Hints()
+		}
+
+		if err := writeOutput(os.Stdout, report); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
+			os.Exit(exitErrOutput)
+		}
+
+		if !caps.FamilyPresent || !caps.NetAdmin {
+			os.Exit(exitDoctorErr)
+		}
+	}
+}
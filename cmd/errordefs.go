@@ -1,17 +1,23 @@
 package cmd
 
 const (
-	exitOk            = 0
-	exitIpvsErrHandle = 20
-	exitIpvsErrQuery  = 21
-	exitInvalidFile   = 30
-	exitApplyErr      = 31
-	exitValidateErr   = 32
-	exitInvalidInput  = 33
-	exitSetErr        = 34
-	exitParamErr      = 35
-	exitNetErr        = 50
-	exitFileErr       = 51
-	exitErrOutput     = 100
-	exitUnknown       = 127
+	exitOk             = 0
+	exitIpvsErrHandle  = 20
+	exitIpvsErrQuery   = 21
+	exitIpvsErrTimeout = 22
+	exitInvalidFile    = 30
+	exitApplyErr       = 31
+	exitValidateErr    = 32
+	exitInvalidInput   = 33
+	exitSetErr         = 34
+	exitParamErr       = 35
+	exitZeroErr        = 36
+	exitConnectionsErr = 37
+	exitDoctorErr      = 38
+	exitImportErr      = 39
+	exitConvertErr     = 40
+	exitNetErr         = 50
+	exitFileErr        = 51
+	exitErrOutput      = 100
+	exitUnknown        = 127
 )
@@ -4,19 +4,25 @@ import (
 	"fmt"
 	"os"
 
-	"gopkg.in/yaml.v2"
-
 	cli "github.com/jawher/mow.cli"
 )
 
 // Get implements the "get" cli command
 func Get(cmd *cli.Cmd) {
+	cmd.Spec = "[--timing]"
+	var (
+		timing = cmd.BoolOpt("timing", false, "Print number of items and query durations to stderr")
+	)
+
 	cmd.Action = func() {
-		b, err := yaml.Marshal(MustGetCurrentConfig())
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "unable to format as yaml\n")
+		currentConfig := MustGetCurrentConfig()
+		if *timing {
+			fmt.Fprintf(os.Stderr, "Queried %s\n", currentConfig.Timing())
+		}
+
+		if err := writeOutput(os.Stdout, currentConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
 			os.Exit(exitErrOutput)
 		}
-		fmt.Printf("%s", string(b))
 	}
 }
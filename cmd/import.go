@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	cli "github.com/jawher/mow.cli"
+)
+
+// Import implements the "import" cli command
+func Import(cmd *cli.Cmd) {
+	cmd.Spec = "--from=<FORMAT> [-f=<FILENAME>]"
+	var (
+		from     = cmd.StringOpt("from", "", "Format of the rules to import, ipvsadm for the output of ipvsadm -Sn")
+		filename = cmd.StringOpt("f", "-", "File to import. Use - for STDIN")
+	)
+
+	cmd.Action = func() {
+		if *from != "ipvsadm" {
+			fmt.Fprintf(os.Stderr, "Invalid format %s. Must be ipvsadm\n", *from)
+			os.Exit(exitInvalidInput)
+		}
+
+		b, _ := readInput(filename)
+
+		c, err := integration.ParseIpvsadmRules(bytes.NewReader(b))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitImportErr)
+		}
+
+		if err := writeOutput(os.Stdout, c); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
+			os.Exit(exitErrOutput)
+		}
+	}
+}
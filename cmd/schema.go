@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	cli "github.com/jawher/mow.cli"
+)
+
+// Schema implements the "schema" cli command
+func Schema(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		if err := writeJSON(os.Stdout, integration.Schema()); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
+			os.Exit(exitErrOutput)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 // Set implements the "set" cli command
 func Set(cmd *cli.Cmd) {
 	cmd.Command("weight", "set weight of a single destination", SetWeight)
+	cmd.Command("timeouts", "set global connection timeouts", SetTimeouts)
 }
 
 // SetWeight implements the weight setting command
@@ -42,10 +43,17 @@ func SetWeight(cmd *cli.Cmd) {
 			os.Exit(exitInvalidInput)
 		}
 
+		currentConfig := MustGetCurrentConfig()
+
 		if *timeSecs <= 0 {
-			err := MustGetCurrentConfig().SetWeight(*service, *destination, *weight)
+			ctx, cancel := newContext()
+			defer cancel()
+			err := currentConfig.SetWeightContext(ctx, *service, *destination, *weight)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to get current config: %s\n", err)
+				if isTimeout(err) {
+					os.Exit(exitIpvsErrTimeout)
+				}
 				os.Exit(exitSetErr)
 			}
 		} else {
@@ -61,12 +69,53 @@ func SetWeight(cmd *cli.Cmd) {
 				ch <- integration.ControlFinish
 			}()
 
-			err := MustGetCurrentConfig().SetWeightContinuous(*service, *destination, *weight, *timeSecs, ch)
+			ctx, cancel := newContextFor(time.Duration(*timeSecs) * time.Second)
+			defer cancel()
+			err := currentConfig.SetWeightContinuousContext(ctx, *service, *destination, *weight, *timeSecs, ch)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to set new weight: %s\n", err)
+				if isTimeout(err) {
+					os.Exit(exitIpvsErrTimeout)
+				}
 				os.Exit(exitSetErr)
 			}
 		}
 
 	}
 }
+
+// SetTimeouts implements the timeouts setting command
+func SetTimeouts(cmd *cli.Cmd) {
+
+	cmd.Spec = "[--tcp=<DURATION>] [--tcpfin=<DURATION>] [--udp=<DURATION>]"
+	var (
+		tcp    = cmd.StringOpt("tcp", "", "Timeout of established tcp connections, e.g. 15m")
+		tcpfin = cmd.StringOpt("tcpfin", "", "Timeout of tcp connections after receiving a FIN, e.g. 2m")
+		udp    = cmd.StringOpt("udp", "", "Timeout of udp packets, e.g. 5m")
+	)
+
+	cmd.Action = func() {
+
+		if *tcp == "" && *tcpfin == "" && *udp == "" {
+			fmt.Fprintln(os.Stderr, "Must specify at least one of --tcp, --tcpfin or --udp")
+			os.Exit(exitInvalidInput)
+		}
+
+		currentConfig := MustGetCurrentConfig()
+
+		ctx, cancel := newContext()
+		defer cancel()
+		err := currentConfig.SetTimeoutsContext(ctx, &integration.Timeouts{
+			TCP:    *tcp,
+			TCPFin: *tcpfin,
+			UDP:    *udp,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to set new timeouts: %s\n", err)
+			if isTimeout(err) {
+				os.Exit(exitIpvsErrTimeout)
+			}
+			os.Exit(exitSetErr)
+		}
+	}
+}
@@ -4,18 +4,28 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/aschmidt75/ipvsctl/config"
 	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/aschmidt75/ipvsctl/ipvs"
 	cli "github.com/jawher/mow.cli"
 )
 
+// validateReport is the output of the validate command for the yaml and json output formats
+type validateReport struct {
+	Valid  bool                          `yaml:"valid" json:"valid"`
+	Issues []integration.ValidationIssue `yaml:"issues,omitempty" json:"issues,omitempty"`
+}
+
 // Validate implements the "validate" cli command
 func Validate(cmd *cli.Cmd) {
-	cmd.Spec = "[-f=<FILENAME>]"
+	cmd.Spec = "[-f=<FILENAME>] [--kernel]"
 	var (
 		filename = cmd.StringOpt("f", "/etc/ipvsctl.yaml", "File to apply. Use - for STDIN")
+		kernel   = cmd.BoolOpt("kernel", false, "Also check against capabilities of the running kernel, see doctor")
 	)
 
 	cmd.Action = func() {
+		checkOutputFormat("validate")
 
 		if *filename == "" {
 			fmt.Fprintf(os.Stderr, "Must specify an input file\n")
@@ -34,15 +44,36 @@ func Validate(cmd *cli.Cmd) {
 			os.Exit(exitParamErr)
 		}
 
-		//
-		err = cr.Validate()
-		if err != nil {
-			e := err.(*integration.IPVSValidateError)
-			fmt.Fprintf(os.Stderr, "Validation error: %s\n", e)
-			os.Exit(exitValidateErr)
+		if *kernel {
+			cr.WithCapabilities(ipvs.Probe())
+		}
+
+		issues := cr.ValidateAll()
+		valid := true
+		for _, issue := range issues {
+			if issue.Severity == integration.SeverityError {
+				valid = false
+			}
+		}
+
+		if config.Config().Output != "" {
+			report := validateReport{Valid: valid, Issues: issues}
+			if err := writeOutput(os.Stdout, report); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to format output: %s\n", err)
+				os.Exit(exitErrOutput)
+			}
+		} else {
+			for _, issue := range issues {
+				fmt.Fprintf(os.Stderr, "%s\n", issue)
+			}
+			if valid {
+				fmt.Println("Configuration valid.")
+			}
 		}
 
-		fmt.Println("Configuration valid.")
+		if !valid {
+			os.Exit(exitValidateErr)
+		}
 		os.Exit(exitOk)
 	}
 }
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	cli "github.com/jawher/mow.cli"
+)
+
+// Zero implements the "zero" cli command
+func Zero(cmd *cli.Cmd) {
+	cmd.Spec = "[--service=<SERVICE>]"
+	var (
+		service = cmd.StringOpt("s service", "", "Handle of service, e.g. tcp://127.0.0.1:80. Default: all services")
+	)
+
+	cmd.Action = func() {
+		currentConfig := MustGetCurrentConfig()
+
+		ctx, cancel := newContext()
+		defer cancel()
+		err := currentConfig.ZeroContext(ctx, *service)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			if isTimeout(err) {
+				os.Exit(exitIpvsErrTimeout)
+			}
+			os.Exit(exitZeroErr)
+		}
+	}
+}
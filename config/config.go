@@ -3,6 +3,7 @@ package config
 import (
 	"io/ioutil"
 	"log"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -16,6 +17,9 @@ type Configuration struct {
 	ParamsFiles        []string
 	ParamsURLsFromEnv  string `env:"IPVSCTL_PARAMS_URLS" envDefault:""`
 	ParamsURLs         []string
+	Netns              string        `env:"IPVSCTL_NETNS" envDefault:""`
+	Timeout            time.Duration `env:"IPVSCTL_TIMEOUT" envDefault:"0s"`
+	Output             string        `env:"IPVSCTL_OUTPUT" envDefault:""`
 
 	log *log.Logger
 }
@@ -1,9 +1,8 @@
 package integration
 
 import (
+	"context"
 	"fmt"
-
-	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
 )
 
 // IPVSApplyError signal an error when applying a new configuration
@@ -19,6 +18,11 @@ func (e *IPVSApplyError) Error() string {
 	return fmt.Sprintf("Unable to apply new config: %s\nReason: %s", e.what, e.origErr)
 }
 
+// Unwrap returns the underlying error, e.g. an ipvs.TimeoutError
+func (e *IPVSApplyError) Unwrap() error {
+	return e.origErr
+}
+
 func isActionAllowed(actions ApplyActions, action ApplyActionType) bool {
 	allowed, found := actions[action]
 	return found && allowed
@@ -27,6 +31,11 @@ func isActionAllowed(actions ApplyActions, action ApplyActionType) bool {
 // Apply compares new config to current config, builds a changeset and
 // applies the change set items within.
 func (ipvsconfig *IPVSConfig) Apply(newconfig *IPVSConfig, opts ApplyOpts) error {
+	return ipvsconfig.ApplyContext(context.Background(), newconfig, opts)
+}
+
+// ApplyContext is like Apply, but honours the deadline and cancellation of ctx.
+func (ipvsconfig *IPVSConfig) ApplyContext(ctx context.Context, newconfig *IPVSConfig, opts ApplyOpts) error {
 
 	// create changeset from new configuration
 	cs, err := ipvsconfig.ChangeSet(newconfig, opts)
@@ -36,16 +45,24 @@ func (ipvsconfig *IPVSConfig) Apply(newconfig *IPVSConfig, opts ApplyOpts) error
 
 	ipvsconfig.log.Printf("Applying changeset, %#v\n", cs)
 
-	return ipvsconfig.ApplyChangeSet(newconfig, cs, opts)
+	return ipvsconfig.ApplyChangeSetContext(ctx, newconfig, cs, opts)
 }
 
 // ApplyChangeSet takes a change set and applies all change items to
 // the given IPVSConfig
 func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSet, opts ApplyOpts) error {
+	return ipvsconfig.ApplyChangeSetContext(context.Background(), newconfig, cs, opts)
+}
+
+// ApplyChangeSetContext is like ApplyChangeSet, but honours the deadline and
+// cancellation of ctx. If ctx is done, remaining change set items are not
+// applied and the error wraps an ipvs.TimeoutError. Items applied so far
+// are not rolled back.
+func (ipvsconfig *IPVSConfig) ApplyChangeSetContext(ctx context.Context, newconfig *IPVSConfig, cs *ChangeSet, opts ApplyOpts) error {
 
-	ipvs, err := ipvs.New("")
+	ipvs, err := ipvsconfig.newHandle()
 	if err != nil {
-		return &IPVSHandleError{}
+		return &IPVSHandleError{origErr: err}
 	}
 	defer ipvs.Close()
 
@@ -89,6 +106,14 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 			if !isActionAllowed(allowedActions, ApplyActionUpdateDestination) {
 				return &IPVSApplyError{what: "not allowed to update a destination"}
 			}
+		case AddSyncDaemon, UpdateSyncDaemon, DeleteSyncDaemon:
+			if !isActionAllowed(allowedActions, ApplyActionSyncDaemon) {
+				return &IPVSApplyError{what: "not allowed to change sync daemons"}
+			}
+		case UpdateTimeouts:
+			if !isActionAllowed(allowedActions, ApplyActionUpdateTimeouts) {
+				return &IPVSApplyError{what: "not allowed to update timeouts"}
+			}
 		default:
 			ipvsconfig.log.Printf("Unhandled change type: %s", csi.Type)
 		}
@@ -106,7 +131,7 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 		case DeleteService:
 			ipvsconfig.log.Printf("Removing service from current config, addr=%s\n", csi.Service.Address)
 
-			err = ipvs.DelService(csi.Service.service)
+			err = ipvs.DelServiceContext(ctx, csi.Service.service)
 			if err != nil {
 				return &IPVSApplyError{what: "unable to delete service", origErr: err}
 			}
@@ -120,7 +145,7 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 
 			ipvsconfig.log.Printf("newIPVSService=%#v\n", newIPVSService)
 
-			err = ipvs.NewService(newIPVSService)
+			err = ipvs.NewServiceContext(ctx, newIPVSService)
 			if err != nil {
 				return &IPVSApplyError{what: "unable to add ipvs service", origErr: err}
 			}
@@ -131,7 +156,7 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 			}
 
 			for _, newIPVSDestination := range newIPVSDestinations {
-				err = ipvs.NewDestination(newIPVSService, newIPVSDestination)
+				err = ipvs.NewDestinationContext(ctx, newIPVSService, newIPVSDestination)
 				if err != nil {
 					return &IPVSApplyError{what: fmt.Sprintf("unable to add new destination %#v for service %s", newIPVSDestination.Address, csi.Service.Address), origErr: err}
 				}
@@ -144,7 +169,7 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 				return &IPVSApplyError{what: "unable to edit service", origErr: err}
 			}
 
-			err = ipvs.UpdateService(newIPVSService)
+			err = ipvs.UpdateServiceContext(ctx, newIPVSService)
 			if err != nil {
 				return &IPVSApplyError{what: "unable to edit ipvs service", origErr: err}
 			}
@@ -157,7 +182,7 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 			if err != nil {
 				return &IPVSApplyError{what: fmt.Sprintf("unable to prepare new destination for service %s", csi.Service.Address), origErr: err}
 			}
-			err = ipvs.NewDestination(csi.Service.service, newIPVSDestination)
+			err = ipvs.NewDestinationContext(ctx, csi.Service.service, newIPVSDestination)
 			if err != nil {
 				return &IPVSApplyError{what: fmt.Sprintf("unable to add new destination %#v for service %s", newIPVSDestination.Address, csi.Service.Address), origErr: err}
 			}
@@ -165,7 +190,7 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 		case DeleteDestination:
 			ipvsconfig.log.Printf("Removing destination from current config, dest=%s, svc=%s\n", csi.Destination.Address, csi.Service.Address)
 
-			err = ipvs.DelDestination(csi.Service.service, csi.Destination.destination)
+			err = ipvs.DelDestinationContext(ctx, csi.Service.service, csi.Destination.destination)
 			if err != nil {
 				return &IPVSApplyError{what: fmt.Sprintf("unable to delete destination %s for service %s", csi.Destination.Address, csi.Service.Address), origErr: err}
 			}
@@ -178,11 +203,63 @@ func (ipvsconfig *IPVSConfig) ApplyChangeSet(newconfig *IPVSConfig, cs *ChangeSe
 				return &IPVSApplyError{what: fmt.Sprintf("unable to prepare edited destination for service %s", csi.Service.Address), origErr: err}
 			}
 			ipvsconfig.log.Printf("Updating destination: %#v\n", updateIPVSDestination)
-			err = ipvs.UpdateDestination(csi.Service.service, updateIPVSDestination)
+			err = ipvs.UpdateDestinationContext(ctx, csi.Service.service, updateIPVSDestination)
 			if err != nil {
 				return &IPVSApplyError{what: fmt.Sprintf("unable to update destination %#v for service %s", updateIPVSDestination.Address, csi.Service.Address), origErr: err}
 			}
 
+		case AddSyncDaemon:
+			ipvsconfig.log.Printf("Starting sync daemon, state=%s\n", csi.SyncDaemon.State)
+
+			newIPVSDaemon, err := newconfig.NewIpvsDaemonStruct(csi.SyncDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to prepare %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+			err = ipvs.NewDaemonContext(ctx, newIPVSDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to start %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+
+		case UpdateSyncDaemon:
+			ipvsconfig.log.Printf("Restarting sync daemon, state=%s\n", csi.SyncDaemon.State)
+
+			newIPVSDaemon, err := newconfig.NewIpvsDaemonStruct(csi.SyncDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to prepare %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+			err = ipvs.DelDaemonContext(ctx, newIPVSDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to stop %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+			err = ipvs.NewDaemonContext(ctx, newIPVSDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to start %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+
+		case DeleteSyncDaemon:
+			ipvsconfig.log.Printf("Stopping sync daemon, state=%s\n", csi.SyncDaemon.State)
+
+			delIPVSDaemon, err := ipvsconfig.NewIpvsDaemonStruct(csi.SyncDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to prepare %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+			err = ipvs.DelDaemonContext(ctx, delIPVSDaemon)
+			if err != nil {
+				return &IPVSApplyError{what: fmt.Sprintf("unable to stop %s sync daemon", csi.SyncDaemon.State), origErr: err}
+			}
+
+		case UpdateTimeouts:
+			ipvsconfig.log.Printf("Updating timeouts, %#v\n", csi.Timeouts)
+
+			newIPVSConfig, err := newconfig.NewIpvsConfigStruct(csi.Timeouts)
+			if err != nil {
+				return &IPVSApplyError{what: "unable to prepare timeouts", origErr: err}
+			}
+			err = ipvs.SetConfigContext(ctx, newIPVSConfig)
+			if err != nil {
+				return &IPVSApplyError{what: "unable to update timeouts", origErr: err}
+			}
+
 		default:
 			ipvsconfig.log.Printf("Unhandled change type %s\n", csi.Type)
 		}
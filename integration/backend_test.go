@@ -0,0 +1,243 @@
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/aschmidt75/ipvsctl/ipvs"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func applyToBackend(t *testing.T, backend ipvs.Backend, model string) error {
+	var newConfig integration.IPVSConfig
+	if err := yaml.Unmarshal([]byte(model), &newConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := newConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	currentConfig := integration.NewIPVSConfigWithLogger(TestLogger).WithBackend(backend)
+	if err := currentConfig.Get(); err != nil {
+		return err
+	}
+	return currentConfig.Apply(&newConfig, integration.ApplyOpts{
+		AllowedActions: integration.AllApplyActions(),
+	})
+}
+
+func getFromBackend(t *testing.T, backend ipvs.Backend) *integration.IPVSConfig {
+	currentConfig := integration.NewIPVSConfigWithLogger(TestLogger).WithBackend(backend)
+	if err := currentConfig.Get(); err != nil {
+		t.Fatal(err)
+	}
+	return currentConfig
+}
+
+func TestApplyGetMemoryBackend(t *testing.T) {
+	backend := ipvs.NewMemory()
+
+	err := applyToBackend(t, backend, `
+services:
+- address: tcp://10.0.0.1:80
+  sched: rr
+  destinations:
+  - address: 10.1.0.1:8080
+    weight: 100
+    forward: nat
+  - address: 10.1.0.2:8080
+    weight: 50
+    forward: nat
+sync:
+- state: master
+  interface: eth0
+timeouts:
+  udp: 10m
+`)
+	assert.Nil(t, err)
+
+	c := getFromBackend(t, backend)
+	assert.Len(t, c.Services, 1)
+	assert.Equal(t, "tcp://10.0.0.1:80", c.Services[0].Address)
+	assert.Len(t, c.Services[0].Destinations, 2)
+	assert.Equal(t, 50, c.Services[0].Destinations[1].Weight)
+	assert.Len(t, c.Sync, 1)
+	assert.Equal(t, "600s", c.Timeouts.UDP)
+	assert.Equal(t, "900s", c.Timeouts.TCP)
+
+	// applying the same model again must not change anything
+	cs, err := c.ChangeSet(c, integration.ApplyOpts{})
+	assert.Nil(t, err)
+	assert.Len(t, cs.Items, 0)
+
+	err = applyToBackend(t, backend, `
+services:
+- address: tcp://10.0.0.1:80
+  sched: wlc
+  destinations:
+  - address: 10.1.0.2:8080
+    weight: 10
+    forward: nat
+`)
+	assert.Nil(t, err)
+
+	c = getFromBackend(t, backend)
+	assert.Len(t, c.Services, 1)
+	assert.Equal(t, "wlc", c.Services[0].SchedName)
+	assert.Len(t, c.Services[0].Destinations, 1)
+	assert.Equal(t, 10, c.Services[0].Destinations[0].Weight)
+
+	err = applyToBackend(t, backend, `{}`)
+	assert.Nil(t, err)
+	assert.Len(t, getFromBackend(t, backend).Services, 0)
+}
+
+func TestApplyChangeSetMemoryBackendErrors(t *testing.T) {
+	backend := ipvs.NewMemory()
+
+	const model = `
+services:
+- address: udp://10.0.0.1:53
+  sched: rr
+`
+	assert.Nil(t, applyToBackend(t, backend, model))
+
+	// adding the same service again is rejected by the backend
+	var newConfig integration.IPVSConfig
+	if err := yaml.Unmarshal([]byte(model), &newConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := newConfig.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cs := integration.NewChangeSet()
+	cs.AddChange(integration.ChangeSetItem{
+		Type:    integration.AddService,
+		Service: newConfig.Services[0],
+	})
+
+	c := getFromBackend(t, backend)
+	err := c.ApplyChangeSet(&newConfig, cs, integration.ApplyOpts{
+		AllowedActions: integration.AllApplyActions(),
+	})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), syscall.EEXIST.Error())
+	}
+}
+
+// fillMemoryBackend adds numServices tcp services with numDestinations each
+func fillMemoryBackend(tb testing.TB, backend *ipvs.Memory, numServices, numDestinations int) {
+	for i := 0; i < numServices; i++ {
+		s := &ipvs.Service{
+			AddressFamily: syscall.AF_INET,
+			Protocol:      syscall.IPPROTO_TCP,
+			Address:       net.IPv4(10, 0, byte(i/256), byte(i%256)),
+			Port:          80,
+			SchedName:     "wrr",
+			Netmask:       0xFFFFFFFF,
+		}
+		if err := backend.NewService(s); err != nil {
+			tb.Fatal(err)
+		}
+		for j := 0; j < numDestinations; j++ {
+			d := &ipvs.Destination{
+				Address: net.IPv4(10, 1+byte(j/65536), byte(j/256), byte(j%256)),
+				Port:    8080,
+				Weight:  1,
+			}
+			if err := backend.NewDestination(s, d); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestGetParallelMemoryBackend(t *testing.T) {
+	backend := ipvs.NewMemory()
+	fillMemoryBackend(t, backend, 100, 20)
+
+	for _, parallelism := range []int{1, 3, 16, 200} {
+		c := integration.NewIPVSConfigWithLogger(TestLogger).WithBackend(backend).WithParallelism(parallelism)
+		assert.Nil(t, c.Get())
+
+		assert.Len(t, c.Services, 100)
+		assert.Equal(t, "tcp://10.0.0.0:80", c.Services[0].Address)
+		assert.Equal(t, "tcp://10.0.0.99:80", c.Services[99].Address)
+		for _, s := range c.Services {
+			assert.Len(t, s.Destinations, 20)
+		}
+
+		timing := c.Timing()
+		assert.Equal(t, 100, timing.Services)
+		assert.Equal(t, 2000, timing.Destinations)
+		assert.True(t, timing.Total >= timing.DestinationsDuration)
+	}
+}
+
+func TestContextMemoryBackend(t *testing.T) {
+	backend := ipvs.NewMemory()
+	fillMemoryBackend(t, backend, 10, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var timeoutErr *ipvs.TimeoutError
+
+	c := integration.NewIPVSConfigWithLogger(TestLogger).WithBackend(backend)
+	err := c.GetContext(ctx)
+	assert.True(t, errors.As(err, &timeoutErr), "expected timeout error, got %v", err)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	current := getFromBackend(t, backend)
+	var newConfig integration.IPVSConfig
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+services:
+- address: tcp://10.1.0.1:80
+  sched: rr
+`), &newConfig))
+	err = current.ApplyContext(ctx, &newConfig, integration.ApplyOpts{
+		AllowedActions: integration.AllApplyActions(),
+	})
+	assert.True(t, errors.As(err, &timeoutErr), "expected timeout error, got %v", err)
+
+	// nothing has been applied
+	assert.Len(t, getFromBackend(t, backend).Services, 10)
+
+	err = current.ZeroContext(ctx, "")
+	assert.True(t, errors.As(err, &timeoutErr), "expected timeout error, got %v", err)
+
+	err = current.SetWeightContext(ctx, "tcp://10.0.0.0:80", "10.1.0.0:8080", 5)
+	assert.True(t, errors.As(err, &timeoutErr), "expected timeout error, got %v", err)
+	assert.Equal(t, 1, getFromBackend(t, backend).Services[0].Destinations[0].Weight)
+
+	err = current.SetTimeoutsContext(ctx, &integration.Timeouts{TCP: "15m"})
+	assert.True(t, errors.As(err, &timeoutErr), "expected timeout error, got %v", err)
+}
+
+func BenchmarkGetMemoryBackend(b *testing.B) {
+	for _, size := range []struct {
+		services, destinations int
+	}{
+		{100, 50},
+		{1000, 50},
+		{50000, 1},
+	} {
+		backend := ipvs.NewMemory()
+		fillMemoryBackend(b, backend, size.services, size.destinations)
+
+		b.Run(fmt.Sprintf("%dx%d", size.services, size.destinations), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := integration.NewIPVSConfigWithLogger(TestLogger).WithBackend(backend)
+				if err := c.Get(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -78,20 +78,12 @@ func (ipvsconfig *IPVSConfig) ChangeSet(newconfig *IPVSConfig, opts ApplyOpts) (
 				return res, err
 			}
 			if equal {
-				newSched := newService.SchedName
-				if newSched == "" {
-					// default given?
-					if newconfig.Defaults.SchedName != nil {
-						newSched = *newconfig.Defaults.SchedName
-					}
-				}
-				if newSched == "" {
-					// still empty? rr is the primary default scheduler
-					newSched = "rr"
+				// same scheduler and persistence?
+				equal, err = CompareServicesEquality(ipvsconfig, service, newconfig, newService)
+				if err != nil {
+					return res, err
 				}
-
-				// same scheduler?
-				if service.SchedName != newSched {
+				if !equal {
 					// no, update service
 					res.AddChange(ChangeSetItem{
 						Type:        UpdateService,
@@ -173,7 +165,11 @@ func (ipvsconfig *IPVSConfig) ChangeSet(newconfig *IPVSConfig, opts ApplyOpts) (
 				for _, destination := range service.Destinations {
 					for _, newDestination := range newService.Destinations {
 
-						if destination.Address == newDestination.Address {
+						same, err := CompareDestinationIdentifyingEquality(ipvsconfig, destination, newconfig, newDestination)
+						if err != nil {
+							return res, err
+						}
+						if same {
 							equal, err := CompareDestinationsEquality(ipvsconfig, destination, newconfig, newDestination, opts)
 							if err != nil {
 								return res, err
@@ -208,5 +204,67 @@ func (ipvsconfig *IPVSConfig) ChangeSet(newconfig *IPVSConfig, opts ApplyOpts) (
 		}
 	}
 
+	// 4: compare sync daemons, but only if the new model
+	// contains a sync section at all.
+	if newconfig.Sync != nil {
+		for _, sd := range ipvsconfig.Sync {
+			found := false
+			for _, newSd := range newconfig.Sync {
+				if sd.State == newSd.State {
+					found = true
+					if !CompareSyncDaemonsEquality(sd, newSd) {
+						res.AddChange(ChangeSetItem{
+							Type:        UpdateSyncDaemon,
+							Description: fmt.Sprintf("Restarting existing %s sync daemon because details have changed", sd.State),
+							SyncDaemon:  newSd,
+						})
+					}
+				}
+			}
+			if !found {
+				res.AddChange(ChangeSetItem{
+					Type:        DeleteSyncDaemon,
+					Description: fmt.Sprintf("Stopping existing %s sync daemon because it does not exist in updated model any more", sd.State),
+					SyncDaemon:  sd,
+				})
+			}
+		}
+
+		for _, newSd := range newconfig.Sync {
+			found := false
+			for _, sd := range ipvsconfig.Sync {
+				if sd.State == newSd.State {
+					found = true
+				}
+			}
+			if !found {
+				res.AddChange(ChangeSetItem{
+					Type:        AddSyncDaemon,
+					Description: fmt.Sprintf("Starting new %s sync daemon because it does not yet exist", newSd.State),
+					SyncDaemon:  newSd,
+				})
+			}
+		}
+	}
+
+	// 5: compare timeouts, if given in new model
+	if newconfig.Timeouts != nil {
+		current := ipvsconfig.Timeouts
+		if current == nil {
+			current = &Timeouts{}
+		}
+		equal, err := CompareTimeoutsEquality(current, newconfig.Timeouts)
+		if err != nil {
+			return res, err
+		}
+		if !equal {
+			res.AddChange(ChangeSetItem{
+				Type:        UpdateTimeouts,
+				Description: "Updating timeouts because they have changed",
+				Timeouts:    newconfig.Timeouts,
+			})
+		}
+	}
+
 	return res, nil
 }
@@ -1,6 +1,7 @@
 package integration_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	integration "github.com/aschmidt75/ipvsctl/integration"
@@ -241,6 +242,484 @@ services:
 	assert.Equal(t, typeMap[integration.DeleteService], 1)
 }
 
+func TestChangeSetPersistence(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// same persistence in different notations
+	cs, err := buildChangeSet(t, `
+services:
+- address: tcp://127.0.0.1:443
+  sched: rr
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+`, `
+defaults:
+  persistence-netmask: 24
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 5m
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	var tests = []string{`
+services:
+- address: tcp://127.0.0.1:443
+  sched: rr
+`, `
+services:
+- address: tcp://127.0.0.1:443
+  sched: rr
+  persistent: 600s
+  persistence-netmask: 255.255.255.0
+`, `
+services:
+- address: tcp://127.0.0.1:443
+  sched: rr
+  persistent: 300s
+`, `
+services:
+- address: tcp://127.0.0.1:443
+  sched: rr
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+  pe: sip
+`}
+	for _, test := range tests {
+		cs, err = buildChangeSet(t, `
+services:
+- address: tcp://127.0.0.1:443
+  sched: rr
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+`, test)
+
+		if err != nil {
+			t.Errorf(genmsg, err)
+		}
+		assert.Len(t, cs.Items, 1, "Check changeset item count")
+
+		item := cs.Items[0].(integration.ChangeSetItem)
+		assert.Equal(t, item.Type, integration.UpdateService)
+	}
+}
+
+func TestChangeSetFlags(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// same flags in different order and notation
+	cs, err := buildChangeSet(t, `
+services:
+- address: udp://127.0.0.1:53
+  sched: sh
+  flags: [ops, sh-port]
+`, `
+services:
+- address: udp://127.0.0.1:53
+  sched: sh
+  flags: [sh-port, one-packet]
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	cs, err = buildChangeSet(t, `
+services:
+- address: udp://127.0.0.1:53
+  sched: sh
+  flags: [ops, sh-port]
+`, `
+services:
+- address: udp://127.0.0.1:53
+  sched: sh
+  flags: [sh-port]
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 1, "Check changeset item count")
+
+	item := cs.Items[0].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.UpdateService)
+}
+
+func TestChangeSetThresholds(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// thresholds from defaults
+	cs, err := buildChangeSet(t, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    weight: 100
+    forward: nat
+    max-connections: 1000
+`, `
+defaults:
+  max-connections: 1000
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    weight: 100
+    forward: nat
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	cs, err = buildChangeSet(t, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    weight: 100
+    forward: nat
+    max-connections: 1000
+`, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    weight: 100
+    forward: nat
+    max-connections: 1000
+    min-connections: 500
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 1, "Check changeset item count")
+
+	item := cs.Items[0].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.UpdateDestination)
+	assert.Equal(t, item.Destination.MinConnections, 500)
+}
+
+func TestChangeSetTunnel(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// plain ipip equals an omitted tunnel
+	cs, err := buildChangeSet(t, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    forward: tunnel
+`, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    forward: tunnel
+    tunnel:
+      type: ipip
+      checksum: nocsum
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	cs, err = buildChangeSet(t, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    forward: tunnel
+`, `
+services:
+- address: tcp://127.0.0.1:9876
+  sched: rr
+  destinations:
+  - address: 127.0.0.2:1234
+    forward: tunnel
+    tunnel:
+      type: gue
+      port: 6080
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 1, "Check changeset item count")
+
+	item := cs.Items[0].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.UpdateDestination)
+	assert.Equal(t, item.Destination.Tunnel.Type, "gue")
+}
+
+func TestChangeSetIPv6(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// same addresses in different notation must not lead to changes
+	cs, err := buildChangeSet(t, `
+services:
+- address: tcp://[2001:db8::1]:80
+  sched: rr
+  destinations:
+  - address: "[2001:db8::10]:8080"
+    weight: 100
+    forward: nat
+`, `
+services:
+- address: tcp://[2001:0db8:0::1]:80
+  sched: rr
+  destinations:
+  - address: "[2001:db8:0:0::10]:8080"
+    weight: 100
+    forward: nat
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	// update destination weight
+	cs, err = buildChangeSet(t, `
+services:
+- address: tcp://[2001:db8::1]:80
+  sched: rr
+  destinations:
+  - address: "[2001:db8::10]:8080"
+    weight: 100
+    forward: nat
+`, `
+services:
+- address: tcp://[2001:db8::1]:80
+  sched: rr
+  destinations:
+  - address: "[2001:db8::10]:8080"
+    weight: 200
+    forward: nat
+  - address: "[2001:db8::11]:8080"
+    weight: 200
+    forward: nat
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 2, "Check changeset item count")
+
+	typeMap := make(map[integration.ChangeSetItemType]int)
+	for _, item := range cs.Items {
+		csitem := item.(integration.ChangeSetItem)
+		typeMap[csitem.Type]++
+	}
+	assert.Equal(t, typeMap[integration.AddDestination], 1)
+	assert.Equal(t, typeMap[integration.UpdateDestination], 1)
+}
+
+func TestChangeSetSync(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// no sync section leaves daemons untouched
+	cs, err := buildChangeSet(t, `
+sync:
+- state: master
+  interface: eth0
+  sync-id: 1
+`, `{}`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	// kernel defaults are equal to omitted values
+	cs, err = buildChangeSet(t, `
+sync:
+- state: master
+  interface: eth0
+  sync-id: 1
+  group: 224.0.0.81
+  port: 8848
+  ttl: 1
+`, `
+sync:
+- state: master
+  interface: eth0
+  sync-id: 1
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	// mixed test
+	cs, err = buildChangeSet(t, `
+sync:
+- state: master
+  interface: eth0
+  sync-id: 1
+`, `
+sync:
+- state: master
+  interface: eth0
+  sync-id: 2
+- state: backup
+  interface: eth0
+  sync-id: 2
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 2, "Check changeset item count")
+
+	item := cs.Items[0].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.UpdateSyncDaemon)
+	assert.Equal(t, item.SyncDaemon.SyncID, 2)
+	item = cs.Items[1].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.AddSyncDaemon)
+	assert.Equal(t, item.SyncDaemon.State, "backup")
+
+	// empty sync section stops all daemons
+	cs, err = buildChangeSet(t, `
+sync:
+- state: master
+  interface: eth0
+`, `
+sync: []
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 1, "Check changeset item count")
+
+	item = cs.Items[0].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.DeleteSyncDaemon)
+}
+
+func TestChangeSetTimeouts(t *testing.T) {
+
+	genmsg := "Unable to build changeset, but should have been: %w\n"
+
+	// no timeouts section leaves timeouts untouched
+	cs, err := buildChangeSet(t, `
+timeouts:
+  tcp: 900s
+  tcpfin: 120s
+  udp: 300s
+`, `{}`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	// same values in different notation, omitted values are equal
+	cs, err = buildChangeSet(t, `
+timeouts:
+  tcp: 900s
+  tcpfin: 120s
+  udp: 300s
+`, `
+timeouts:
+  tcp: 15m
+  udp: 300
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 0, "ChangeSet must be empty")
+
+	// changed value
+	cs, err = buildChangeSet(t, `
+timeouts:
+  tcp: 900s
+  tcpfin: 120s
+  udp: 300s
+`, `
+timeouts:
+  tcpfin: 1m
+`)
+
+	if err != nil {
+		t.Errorf(genmsg, err)
+	}
+	assert.Len(t, cs.Items, 1, "Check changeset item count")
+
+	item := cs.Items[0].(integration.ChangeSetItem)
+	assert.Equal(t, item.Type, integration.UpdateTimeouts)
+	assert.Equal(t, item.Timeouts.TCPFin, "1m")
+}
+
+func TestChangeSetRoundTrip(t *testing.T) {
+	cs, err := buildChangeSet(t, `
+services:
+- address: tcp://10.0.0.1:80
+  sched: rr
+  destinations:
+  - address: 10.1.0.1:80
+    forward: nat
+`, `
+services:
+- address: tcp://10.0.0.1:80
+  sched: wrr
+  destinations:
+  - address: 10.1.0.2:80
+    forward: nat
+- address: udp://10.0.0.2:53
+  sched: rr
+timeouts:
+  udp: 1m
+`)
+	assert.Nil(t, err)
+	assert.Len(t, cs.Items, 5)
+
+	y, err := yaml.Marshal(cs)
+	assert.Nil(t, err)
+	j, err := json.Marshal(cs)
+	assert.Nil(t, err)
+
+	var fromYAML, fromJSON integration.ChangeSet
+	assert.Nil(t, yaml.Unmarshal(y, &fromYAML))
+	assert.Nil(t, json.Unmarshal(j, &fromJSON))
+
+	for _, decoded := range []integration.ChangeSet{fromYAML, fromJSON} {
+		assert.Len(t, decoded.Items, len(cs.Items))
+		for idx, item := range decoded.Items {
+			csi, ok := item.(integration.ChangeSetItem)
+			assert.True(t, ok, "item %d must be decoded as ChangeSetItem, got %T", idx, item)
+			assert.Equal(t, cs.Items[idx].(integration.ChangeSetItem).Type, csi.Type)
+		}
+
+		y2, err := yaml.Marshal(&decoded)
+		assert.Nil(t, err)
+		assert.Equal(t, string(y), string(y2))
+	}
+}
+
 func buildChangeSet(t *testing.T, baseModel, changeModel string) (*integration.ChangeSet, error) {
 	var err error
 	var baseConfig, changeConfig integration.IPVSConfig
@@ -0,0 +1,327 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netns"
+)
+
+// ConnectionsFile is the kernel's ipvs connection table
+const ConnectionsFile = "/proc/net/ip_vs_conn"
+
+// connectionsFileThread is the connection table as seen from the network
+// namespace of the current thread, used when reading from other namespaces
+const connectionsFileThread = "/proc/thread-self/net/ip_vs_conn"
+
+// IPVSConnectionsError signals an error when reading or filtering connections
+type IPVSConnectionsError struct {
+	what    string
+	origErr error
+}
+
+func (e *IPVSConnectionsError) Error() string {
+	if e.origErr == nil {
+		return fmt.Sprintf("Unable to read connections: %s", e.what)
+	}
+	return fmt.Sprintf("Unable to read connections: %s\nReason: %s", e.what, e.origErr)
+}
+
+// Connection is a single entry of the ipvs connection table
+type Connection struct {
+	Protocol    string `yaml:"protocol" json:"protocol"`                     // tcp, udp or sctp
+	Client      string `yaml:"client" json:"client"`                         // client address and port
+	Service     string `yaml:"service" json:"service"`                       // handle of the virtual service, e.g. tcp://10.0.0.1:80
+	Destination string `yaml:"destination" json:"destination"`               // address and port of the destination
+	State       string `yaml:"state" json:"state"`                           // e.g. ESTABLISHED, FIN_WAIT
+	Expires     int    `yaml:"expires" json:"expires"`                       // seconds until the entry expires
+	PEName      string `yaml:"pe,omitempty" json:"pe,omitempty"`             // persistence engine, e.g. sip
+	PEData      string `yaml:"pe-data,omitempty" json:"pe-data,omitempty"`   // persistence engine data, e.g. a call id
+	Template    bool   `yaml:"template,omitempty" json:"template,omitempty"` // persistence template, not a connection
+
+	clientIP      net.IP
+	destinationIP net.IP
+}
+
+// ClientIP returns the address of the client
+func (c *Connection) ClientIP() net.IP {
+	return c.clientIP
+}
+
+// DestinationIP returns the address of the destination
+func (c *Connection) DestinationIP() net.IP {
+	return c.destinationIP
+}
+
+// ConnectionFilter selects connections. Empty fields match all connections.
+type ConnectionFilter struct {
+	Service     string // service handle, e.g. tcp://10.0.0.1:80
+	Destination string // destination address, e.g. 10.1.0.1:8080
+	Client      string // client address or CIDR, e.g. 192.168.0.0/16
+	State       string // connection state, case-insensitive
+}
+
+// ConnectionSummary contains connection counts in total, per destination and per state.
+// Persistence templates are counted separately.
+type ConnectionSummary struct {
+	Total          int            `yaml:"total" json:"total"`
+	Templates      int            `yaml:"templates,omitempty" json:"templates,omitempty"`
+	PerDestination map[string]int `yaml:"per-destination,omitempty" json:"per-destination,omitempty"`
+	PerState       map[string]int `yaml:"per-state,omitempty" json:"per-state,omitempty"`
+}
+
+// Connections reads the connection table within the configured namespace
+// and returns all connections matching filter.
+func (ipvsconfig *IPVSConfig) Connections(filter ConnectionFilter) ([]*Connection, error) {
+	conns, err := ipvsconfig.readConnections()
+	if err != nil {
+		return nil, err
+	}
+	ipvsconfig.log.Printf("Read %d connections\n", len(conns))
+
+	return FilterConnections(conns, filter)
+}
+
+func (ipvsconfig *IPVSConfig) readConnections() (res []*Connection, err error) {
+	if ipvsconfig.namespace == "" {
+		return readConnectionsFile(ConnectionsFile)
+	}
+
+	// /proc/net reflects the namespace of the main thread, so switch
+	// namespaces of a locked thread and read it via thread-self
+	runtime.LockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, &IPVSConnectionsError{what: "unable to get current network namespace", origErr: err}
+	}
+	defer orig.Close()
+
+	target, err := netns.GetFromPath(ipvsconfig.namespace)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, &IPVSConnectionsError{what: fmt.Sprintf("unable to open network namespace %s", ipvsconfig.namespace), origErr: err}
+	}
+	defer target.Close()
+
+	if err = netns.Set(target); err != nil {
+		runtime.UnlockOSThread()
+		return nil, &IPVSConnectionsError{what: fmt.Sprintf("unable to enter network namespace %s", ipvsconfig.namespace), origErr: err}
+	}
+	defer func() {
+		// a thread which cannot be restored stays locked to this goroutine,
+		// so the runtime never schedules other goroutines on it
+		if restoreErr := netns.Set(orig); restoreErr != nil {
+			res = nil
+			err = &IPVSConnectionsError{what: "unable to restore network namespace", origErr: restoreErr}
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+
+	return readConnectionsFile(connectionsFileThread)
+}
+
+func readConnectionsFile(fileName string) ([]*Connection, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, &IPVSConnectionsError{what: fmt.Sprintf("unable to open %s. Is the kernel module loaded?", fileName), origErr: err}
+	}
+	defer f.Close()
+
+	return ParseConnections(f)
+}
+
+// ParseConnections parses a connection table in the format of /proc/net/ip_vs_conn
+func ParseConnections(r io.Reader) ([]*Connection, error) {
+	res := []*Connection{}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "Pro ") {
+			continue
+		}
+
+		c, err := parseConnection(line)
+		if err != nil {
+			return nil, &IPVSConnectionsError{what: fmt.Sprintf("parse error in line %d", lineNo), origErr: err}
+		}
+		res = append(res, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &IPVSConnectionsError{what: "unable to read connection table", origErr: err}
+	}
+
+	return res, nil
+}
+
+// parseConnection parses a single line of the connection table, e.g.
+// TCP C0A80001 D7A6 0A000001 0050 0A010001 1F90 ESTABLISHED     899
+func parseConnection(line string) (*Connection, error) {
+	f := strings.Fields(line)
+	if len(f) < 9 {
+		return nil, fmt.Errorf("expected at least 9 fields, got %d", len(f))
+	}
+
+	var ips [3]net.IP
+	var ports [3]int
+	for idx := range ips {
+		ip, err := parseConnectionIP(f[1+2*idx])
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(f[2+2*idx], 16, 16)
+		if err != nil {
+			return nil, err
+		}
+		ips[idx] = ip
+		ports[idx] = int(port)
+	}
+
+	expires, err := strconv.Atoi(f[8])
+	if err != nil {
+		return nil, err
+	}
+
+	proto := strings.ToLower(f[0])
+	c := &Connection{
+		Protocol:      proto,
+		Client:        joinHostPort(ips[0].String(), ports[0]),
+		Service:       fmt.Sprintf("%s://%s", proto, joinHostPort(ips[1].String(), ports[1])),
+		Destination:   net.JoinHostPort(ips[2].String(), strconv.Itoa(ports[2])),
+		State:         f[7],
+		Expires:       expires,
+		clientIP:      ips[0],
+		destinationIP: ips[2],
+	}
+	// persistence templates only carry the client address, templates
+	// of fwmark services have protocol IP
+	c.Template = proto == "ip" || ports[0] == 0
+	if len(f) > 9 {
+		c.PEName = f[9]
+	}
+	if len(f) > 10 {
+		c.PEData = strings.Join(f[10:], " ")
+	}
+
+	return c, nil
+}
+
+// parseConnectionIP parses an address of the connection table, which is
+// hexadecimal for ipv4 and in full notation for ipv6
+func parseConnectionIP(in string) (net.IP, error) {
+	if strings.Contains(in, ":") {
+		ip := net.ParseIP(in)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %s", in)
+		}
+		return ip, nil
+	}
+
+	b, err := hex.DecodeString(in)
+	if err != nil || len(b) != 4 {
+		return nil, fmt.Errorf("invalid address %s", in)
+	}
+	return net.IPv4(b[0], b[1], b[2], b[3]).To4(), nil
+}
+
+// FilterConnections returns all connections matching filter
+func FilterConnections(conns []*Connection, filter ConnectionFilter) ([]*Connection, error) {
+	var service, destination string
+	var client *net.IPNet
+
+	if filter.Service != "" {
+		service = normalizeServiceHandle(filter.Service)
+		if strings.HasPrefix(service, "fwmark:") {
+			return nil, &IPVSConnectionsError{what: "fwmark services cannot be filtered, because the connection table does not contain marks"}
+		}
+	}
+	if filter.Destination != "" {
+		destination = normalizeDestinationHandle(filter.Destination)
+	}
+	if filter.Client != "" {
+		var err error
+		client, err = parseClientFilter(filter.Client)
+		if err != nil {
+			return nil, &IPVSConnectionsError{what: fmt.Sprintf("invalid client filter %s", filter.Client), origErr: err}
+		}
+	}
+
+	res := []*Connection{}
+	for _, c := range conns {
+		if service != "" && c.Service != service {
+			continue
+		}
+		if destination != "" && c.Destination != destination {
+			continue
+		}
+		if client != nil && !client.Contains(c.clientIP) {
+			continue
+		}
+		if filter.State != "" && !strings.EqualFold(c.State, filter.State) {
+			continue
+		}
+		res = append(res, c)
+	}
+
+	return res, nil
+}
+
+// parseClientFilter parses a CIDR or a single address
+func parseClientFilter(in string) (*net.IPNet, error) {
+	if strings.Contains(in, "/") {
+		_, n, err := net.ParseCIDR(in)
+		return n, err
+	}
+	ip := net.ParseIP(in)
+	if ip == nil {
+		return nil, fmt.Errorf("not an address or CIDR")
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// SummarizeConnections counts connections per destination and per state.
+// Persistence templates are only counted in Templates.
+func SummarizeConnections(conns []*Connection) *ConnectionSummary {
+	res := &ConnectionSummary{
+		PerDestination: make(map[string]int),
+		PerState:       make(map[string]int),
+	}
+	for _, c := range conns {
+		if c.Template {
+			res.Templates++
+			continue
+		}
+		res.Total++
+		res.PerDestination[c.Destination]++
+		res.PerState[c.State]++
+	}
+	return res
+}
+
+// SortedKeys returns the keys of a summary map in ascending order
+func SortedKeys(m map[string]int) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
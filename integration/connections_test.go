@@ -0,0 +1,111 @@
+package integration_test
+
+import (
+	"strings"
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+const connectionTable = `Pro FromIP   FPrt ToIP     TPrt DestIP   DPrt State       Expires PEName PEData
+TCP C0A80001 D7A6 0A000001 0050 0A010001 1F90 ESTABLISHED     899
+TCP C0A80002 D7A7 0A000001 0050 0A010002 1F90 FIN_WAIT         60
+UDP AC100001 1388 0A000002 13C4 0A010001 13C4 UDP             290 sip abc@example.com
+TCP 2001:0db8:0000:0000:0000:0000:0000:0001 C350 2001:0db8:0000:0000:0000:0000:0000:0080 01BB 2001:0db8:0000:0000:0000:0000:0001:0001 01BB ESTABLISHED     100
+`
+
+func TestParseConnections(t *testing.T) {
+	conns, err := integration.ParseConnections(strings.NewReader(connectionTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, conns, 4)
+
+	c := conns[0]
+	assert.Equal(t, "tcp", c.Protocol)
+	assert.Equal(t, "192.168.0.1:55206", c.Client)
+	assert.Equal(t, "tcp://10.0.0.1:80", c.Service)
+	assert.Equal(t, "10.1.0.1:8080", c.Destination)
+	assert.Equal(t, "ESTABLISHED", c.State)
+	assert.Equal(t, 899, c.Expires)
+
+	c = conns[2]
+	assert.Equal(t, "sip", c.PEName)
+	assert.Equal(t, "abc@example.com", c.PEData)
+
+	c = conns[3]
+	assert.Equal(t, "[2001:db8::1]:50000", c.Client)
+	assert.Equal(t, "tcp://[2001:db8::80]:443", c.Service)
+	assert.Equal(t, "[2001:db8::1:1]:443", c.Destination)
+
+	_, err = integration.ParseConnections(strings.NewReader("TCP XYZ 0050\n"))
+	assert.NotNil(t, err)
+}
+
+func TestFilterConnections(t *testing.T) {
+	conns, err := integration.ParseConnections(strings.NewReader(connectionTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		filter integration.ConnectionFilter
+		count  int
+	}{
+		{integration.ConnectionFilter{}, 4},
+		{integration.ConnectionFilter{Service: "tcp://10.0.0.1:80"}, 2},
+		{integration.ConnectionFilter{Service: "tcp://[2001:db8:0::80]:443"}, 1},
+		{integration.ConnectionFilter{Destination: "10.1.0.1:8080"}, 1},
+		{integration.ConnectionFilter{Client: "192.168.0.0/16"}, 2},
+		{integration.ConnectionFilter{Client: "172.16.0.1"}, 1},
+		{integration.ConnectionFilter{Client: "2001:db8::/64"}, 1},
+		{integration.ConnectionFilter{State: "established"}, 2},
+		{integration.ConnectionFilter{Service: "tcp://10.0.0.1:80", State: "FIN_WAIT"}, 1},
+	}
+
+	for _, test := range tests {
+		res, err := integration.FilterConnections(conns, test.filter)
+		assert.Nil(t, err)
+		assert.Len(t, res, test.count, "filter %#v", test.filter)
+	}
+
+	_, err = integration.FilterConnections(conns, integration.ConnectionFilter{Client: "nonsense"})
+	assert.NotNil(t, err)
+	_, err = integration.FilterConnections(conns, integration.ConnectionFilter{Service: "fwmark:1"})
+	assert.NotNil(t, err)
+}
+
+func TestSummarizeConnections(t *testing.T) {
+	conns, err := integration.ParseConnections(strings.NewReader(connectionTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := integration.SummarizeConnections(conns)
+	assert.Equal(t, 4, s.Total)
+	assert.Equal(t, 1, s.PerDestination["10.1.0.1:8080"])
+	assert.Equal(t, 1, s.PerDestination["10.1.0.1:5060"])
+	assert.Equal(t, 2, s.PerState["ESTABLISHED"])
+	assert.Equal(t, []string{"ESTABLISHED", "FIN_WAIT", "UDP"}, integration.SortedKeys(s.PerState))
+}
+
+func TestSummarizeConnectionsTemplates(t *testing.T) {
+	conns, err := integration.ParseConnections(strings.NewReader(connectionTable +
+		"TCP C0A80001 0000 0A000001 0050 0A010001 1F90 NONE            299\n" +
+		"IP  C0A80002 0000 0000002A 0000 0A010002 0000 NONE            120\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, conns, 6)
+	assert.False(t, conns[0].Template)
+	assert.True(t, conns[4].Template)
+	assert.Equal(t, "192.168.0.1", conns[4].Client)
+	assert.True(t, conns[5].Template)
+
+	s := integration.SummarizeConnections(conns)
+	assert.Equal(t, 4, s.Total)
+	assert.Equal(t, 2, s.Templates)
+	assert.Equal(t, 1, s.PerDestination["10.1.0.1:8080"])
+	assert.Equal(t, 0, s.PerState["NONE"])
+}
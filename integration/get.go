@@ -1,7 +1,13 @@
 package integration
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
 	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
 )
@@ -20,33 +26,79 @@ func protoNumToStr(service *ipvs.Service) string {
 }
 
 // IPVSHandleError signals that we cannot obtain an ipvs handle
-type IPVSHandleError struct{}
+type IPVSHandleError struct {
+	origErr error
+}
 
 func (e *IPVSHandleError) Error() string {
-	return "Unable to create IPVS handle. Is the kernel module installed and active?"
+	if e.origErr == nil {
+		return "Unable to create IPVS handle. Is the kernel module installed and active?"
+	}
+	return fmt.Sprintf("Unable to create IPVS handle. Is the kernel module installed and active?\nReason: %s", e.origErr)
+}
+
+// Unwrap returns the underlying error, e.g. an ipvs.FamilyNotFoundError
+func (e *IPVSHandleError) Unwrap() error {
+	return e.origErr
 }
 
 // IPVSQueryError signal an error when querying data
 type IPVSQueryError struct {
-	what string
+	what    string
+	origErr error
 }
 
 func (e *IPVSQueryError) Error() string {
+	var timeoutErr *ipvs.TimeoutError
+	if errors.As(e.origErr, &timeoutErr) {
+		return fmt.Sprintf("Unable to query IPVS (%s): %s", e.what, e.origErr)
+	}
 	return fmt.Sprintf("Unable to query IPVS (%s). Is the kernel module installed and active?", e.what)
 }
 
+// Unwrap returns the underlying error, e.g. an ipvs.TimeoutError
+func (e *IPVSQueryError) Unwrap() error {
+	return e.origErr
+}
+
 // Get retrieves the current IPVC config with all services and destinations
 func (ipvsconfig *IPVSConfig) Get() error {
+	return ipvsconfig.GetContext(context.Background())
+}
+
+// GetContext is like Get, but honours the deadline and cancellation of ctx.
+// If ctx is done before all data is queried, the error wraps an ipvs.TimeoutError.
+func (ipvsconfig *IPVSConfig) GetContext(ctx context.Context) error {
 	ipvsconfig.log.Printf("Querying ipvs data...\n")
+	start := time.Now()
+	ipvsconfig.timing = GetTiming{}
 
-	ipvs, err := ipvs.New("")
+	ipvs, err := ipvsconfig.newHandle()
 	if err != nil {
-		return &IPVSHandleError{}
+		return &IPVSHandleError{origErr: err}
 	}
 	ipvsconfig.log.Printf("%#v\n", ipvs)
 	defer ipvs.Close()
 
-	return getServicesWithDestinations(ipvs, ipvsconfig)
+	err = getServicesWithDestinations(ctx, ipvs, ipvsconfig)
+	if err != nil {
+		return err
+	}
+
+	err = getSyncDaemons(ctx, ipvs, ipvsconfig)
+	if err != nil {
+		return err
+	}
+
+	err = getTimeouts(ctx, ipvs, ipvsconfig)
+	if err != nil {
+		return err
+	}
+
+	ipvsconfig.timing.Total = time.Since(start)
+	ipvsconfig.log.Printf("Queried %s\n", ipvsconfig.timing)
+
+	return nil
 }
 
 func getForward(d *ipvs.Destination) string {
@@ -68,14 +120,14 @@ func getForward(d *ipvs.Destination) string {
 // MakeAdressStringFromIpvsDestination creates a model-valid address
 // string from an ipvs.Destination entry.
 func MakeAdressStringFromIpvsDestination(dest *ipvs.Destination) string {
-	return fmt.Sprintf("%s:%d", dest.Address, dest.Port)
+	return net.JoinHostPort(dest.Address.String(), strconv.Itoa(int(dest.Port)))
 }
 
-func getDestinationsForService(ipvs *ipvs.Handle, service *ipvs.Service, s *Service) error {
+func getDestinationsForService(ctx context.Context, ipvs ipvs.Backend, service *ipvs.Service, s *Service) error {
 	//
-	dests, err := ipvs.GetDestinations(service)
+	dests, err := ipvs.GetDestinationsContext(ctx, service)
 	if err != nil {
-		return &IPVSQueryError{what: "destinations"}
+		return &IPVSQueryError{what: "destinations", origErr: err}
 	}
 
 	if dests != nil && len(dests) > 0 {
@@ -83,10 +135,13 @@ func getDestinationsForService(ipvs *ipvs.Handle, service *ipvs.Service, s *Serv
 
 		for idx, dest := range dests {
 			s.Destinations[idx] = &Destination{
-				Address:     MakeAdressStringFromIpvsDestination(dest),
-				Weight:      dest.Weight,
-				Forward:     getForward(dest),
-				destination: dest,
+				Address:        MakeAdressStringFromIpvsDestination(dest),
+				Weight:         dest.Weight,
+				Forward:        getForward(dest),
+				MaxConnections: int(dest.UpperThreshold),
+				MinConnections: int(dest.LowerThreshold),
+				Tunnel:         tunnelFromIpvs(dest),
+				destination:    dest,
 			}
 		}
 	}
@@ -100,12 +155,7 @@ func MakeAdressStringFromIpvsService(service *ipvs.Service) string {
 	var adrStr string
 	if service.Protocol != 0 {
 		protoStr := protoNumToStr(service)
-		ipStr := fmt.Sprintf("%s", service.Address)
-		var portStr = ""
-		if service.Port != 0 {
-			portStr = fmt.Sprintf(":%d", service.Port)
-		}
-		adrStr = fmt.Sprintf("%s://%s%s", protoStr, ipStr, portStr)
+		adrStr = fmt.Sprintf("%s://%s", protoStr, joinHostPort(service.Address.String(), int(service.Port)))
 	} else {
 		adrStr = fmt.Sprintf("fwmark:%d", service.FWMark)
 	}
@@ -113,35 +163,188 @@ func MakeAdressStringFromIpvsService(service *ipvs.Service) string {
 	return adrStr
 }
 
-func getServicesWithDestinations(ipvs *ipvs.Handle, res *IPVSConfig) error {
-	services, err := ipvs.GetServices()
+// newServiceFromIpvs creates a model service (without destinations)
+// from an ipvs.Service entry.
+func newServiceFromIpvs(service *ipvs.Service) *Service {
+	s := &Service{
+		Address:   MakeAdressStringFromIpvsService(service),
+		SchedName: service.SchedName,
+		Flags:     serviceFlagsToStrings(service.Flags&serviceFlagsMask, service.SchedName),
+		PEName:    service.PEName,
+		service:   service,
+	}
+	if service.Flags&ipvs.SvcFlagPersistent != 0 {
+		s.Persistent = fmt.Sprintf("%ds", service.Timeout)
+
+		ones := netmaskFromIpvs(service.AddressFamily, service.Netmask)
+		if ones != maxPrefixLen(service.AddressFamily) {
+			s.PersistenceNetmask = formatPersistenceNetmask(service.AddressFamily, ones)
+		}
+	}
+
+	return s
+}
+
+// DefaultParallelism is the default number of destination queries Get runs in parallel
+const DefaultParallelism = 4
+
+// GetTiming contains counts and durations of the phases of a Get
+type GetTiming struct {
+	Services             int
+	Destinations         int
+	ServicesDuration     time.Duration // dumping all services
+	DestinationsDuration time.Duration // dumping destinations of all services
+	Total                time.Duration
+}
+
+func (t GetTiming) String() string {
+	return fmt.Sprintf("%d services in %s, %d destinations in %s, total %s",
+		t.Services, t.ServicesDuration, t.Destinations, t.DestinationsDuration, t.Total)
+}
+
+// Timing returns counts and durations of the last Get
+func (ipvsconfig *IPVSConfig) Timing() GetTiming {
+	return ipvsconfig.timing
+}
+
+// getServicesWithDestinations builds all services from a single dump and
+// dumps their destinations in parallel
+func getServicesWithDestinations(ctx context.Context, ipvs ipvs.Backend, res *IPVSConfig) error {
+	start := time.Now()
+	services, err := ipvs.GetServicesContext(ctx)
 	if err != nil {
-		return &IPVSQueryError{what: "services"}
+		return &IPVSQueryError{what: "services", origErr: err}
 	}
-	res.log.Printf("%#v\n", services)
-	if services != nil {
-		res.Services = make([]*Service, len(services))
+	res.timing.ServicesDuration = time.Since(start)
+	res.timing.Services = len(services)
 
+	res.Services = nil
+	if len(services) > 0 {
+		res.Services = make([]*Service, len(services))
 		for idx, service := range services {
-			service, err = ipvs.GetService(service)
-			if err != nil {
-				return &IPVSQueryError{what: "service"}
-			}
+			res.Services[idx] = newServiceFromIpvs(service)
+		}
+	}
+
+	start = time.Now()
+	err = res.getAllDestinations(ctx, ipvs)
+	if err != nil {
+		return err
+	}
+	res.timing.DestinationsDuration = time.Since(start)
+	for _, s := range res.Services {
+		res.timing.Destinations += len(s.Destinations)
+	}
+
+	return nil
+}
+
+// getAllDestinations queries the destinations of all services, using up to
+// parallelism handles. The first one is the given handle, all others are
+// opened for this purpose.
+func (ipvsconfig *IPVSConfig) getAllDestinations(ctx context.Context, handle ipvs.Backend) error {
+	workers := ipvsconfig.parallelism
+	if workers <= 0 {
+		workers = DefaultParallelism
+	}
+	if workers > len(ipvsconfig.Services) {
+		workers = len(ipvsconfig.Services)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
 
-			res.log.Printf("%d -> %#v\n", idx, *service)
+	jobs := make(chan *Service)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
 
-			var adrStr = MakeAdressStringFromIpvsService(service)
-			res.Services[idx] = &Service{
-				Address:   adrStr,
-				SchedName: service.SchedName,
-				service:   service,
+			h := handle
+			if w > 0 {
+				var err error
+				h, err = ipvsconfig.newHandle()
+				if err != nil {
+					fail(&IPVSHandleError{origErr: err})
+					for range jobs {
+					}
+					return
+				}
+				defer h.Close()
 			}
 
-			err = getDestinationsForService(ipvs, service, res.Services[idx])
-			if err != nil {
-				return err
+			for s := range jobs {
+				if failed() {
+					continue
+				}
+				if err := getDestinationsForService(ctx, h, s.service, s); err != nil {
+					fail(err)
+				}
 			}
+		}(w)
+	}
+
+	for _, s := range ipvsconfig.Services {
+		jobs <- s
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+func getSyncDaemons(ctx context.Context, ipvs ipvs.Backend, res *IPVSConfig) error {
+	daemons, err := ipvs.GetDaemonsContext(ctx)
+	if err != nil {
+		return &IPVSQueryError{what: "sync daemons", origErr: err}
+	}
+	res.log.Printf("%#v\n", daemons)
+
+	res.Sync = nil
+	for _, daemon := range daemons {
+		sd := &SyncDaemon{
+			State:     syncStateToString(daemon.State),
+			Interface: daemon.McastIfn,
+			SyncID:    int(daemon.SyncID),
+			Port:      int(daemon.McastPort),
+			TTL:       int(daemon.McastTTL),
+			daemon:    daemon,
 		}
+		if daemon.McastGroup != nil {
+			sd.Group = daemon.McastGroup.String()
+		}
+		res.Sync = append(res.Sync, sd)
+	}
+
+	return nil
+}
+
+func getTimeouts(ctx context.Context, ipvs ipvs.Backend, res *IPVSConfig) error {
+	c, err := ipvs.GetConfigContext(ctx)
+	if err != nil {
+		return &IPVSQueryError{what: "timeouts", origErr: err}
+	}
+	res.log.Printf("%#v\n", c)
+
+	res.Timeouts = &Timeouts{
+		TCP:    fmt.Sprintf("%ds", int(c.TimeoutTCP.Seconds())),
+		TCPFin: fmt.Sprintf("%ds", int(c.TimeoutTCPFin.Seconds())),
+		UDP:    fmt.Sprintf("%ds", int(c.TimeoutUDP.Seconds())),
 	}
 
 	return nil
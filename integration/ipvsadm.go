@@ -0,0 +1,506 @@
+package integration
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
+)
+
+// IpvsadmDefaultPersistence is the persistence timeout of ipvsadm's -p
+// option without a value, in seconds
+const IpvsadmDefaultPersistence = 300
+
+// IPVSImportError signals an error when importing rules of another tool
+type IPVSImportError struct {
+	line int // line number of the offending rule, 0 if not line specific
+	what string
+}
+
+func (e *IPVSImportError) Error() string {
+	if e.line == 0 {
+		return fmt.Sprintf("Unable to import rules: %s", e.what)
+	}
+	return fmt.Sprintf("Unable to import rules, line %d: %s", e.line, e.what)
+}
+
+// Line returns the line number of the offending rule, 0 if not line specific
+func (e *IPVSImportError) Line() int {
+	return e.line
+}
+
+// ipvsadmRule contains the options of a single -A or -a rule
+type ipvsadmRule struct {
+	addService bool
+	service    string // model handle, e.g. tcp://10.0.0.1:80
+	ipv6       bool   // -6, only valid for fwmark services
+	opts       map[string]string
+}
+
+// ipvsadmArg determines whether an ipvsadm option takes a value
+type ipvsadmArg int
+
+const (
+	argNone ipvsadmArg = iota
+	argRequired
+	argOptional
+)
+
+// ipvsadmOptions maps short and long options to their canonical name and
+// whether they take a value
+var ipvsadmOptions = map[string]struct {
+	name string
+	arg  ipvsadmArg
+}{
+	"-A":               {"-A", argNone},
+	"--add-service":    {"-A", argNone},
+	"-a":               {"-a", argNone},
+	"--add-server":     {"-a", argNone},
+	"-t":               {"-t", argRequired},
+	"--tcp-service":    {"-t", argRequired},
+	"-u":               {"-u", argRequired},
+	"--udp-service":    {"-u", argRequired},
+	"--sctp-service":   {"--sctp-service", argRequired},
+	"-f":               {"-f", argRequired},
+	"--fwmark-service": {"-f", argRequired},
+	"-6":               {"-6", argNone},
+	"--ipv6":           {"-6", argNone},
+	"-s":               {"-s", argRequired},
+	"--scheduler":      {"-s", argRequired},
+	"-p":               {"-p", argOptional},
+	"--persistent":     {"-p", argOptional},
+	"-M":               {"-M", argRequired},
+	"--netmask":        {"-M", argRequired},
+	"-b":               {"-b", argRequired},
+	"--sched-flags":    {"-b", argRequired},
+	"--pe":             {"--pe", argRequired},
+	"-o":               {"-o", argNone},
+	"--ops":            {"-o", argNone},
+	"-r":               {"-r", argRequired},
+	"--real-server":    {"-r", argRequired},
+	"-g":               {"-g", argNone},
+	"--gatewaying":     {"-g", argNone},
+	"-i":               {"-i", argNone},
+	"--ipip":           {"-i", argNone},
+	"-m":               {"-m", argNone},
+	"--masquerading":   {"-m", argNone},
+	"-w":               {"-w", argRequired},
+	"--weight":         {"-w", argRequired},
+	"-x":               {"-x", argRequired},
+	"--u-threshold":    {"-x", argRequired},
+	"-y":               {"-y", argRequired},
+	"--l-threshold":    {"-y", argRequired},
+	"--tun-type":       {"--tun-type", argRequired},
+	"--tun-port":       {"--tun-port", argRequired},
+	"--tun-nocsum":     {"--tun-nocsum", argNone},
+	"--tun-csum":       {"--tun-csum", argNone},
+	"--tun-remcsum":    {"--tun-remcsum", argNone},
+}
+
+// ipvsadmServiceOptions and ipvsadmServerOptions contain the options valid
+// for -A and -a rules, besides the service
+var (
+	ipvsadmServiceOptions = map[string]bool{"-s": true, "-p": true, "-M": true, "-b": true, "--pe": true, "-o": true}
+	ipvsadmServerOptions  = map[string]bool{"-r": true, "-g": true, "-i": true, "-m": true, "-w": true, "-x": true, "-y": true,
+		"--tun-type": true, "--tun-port": true, "--tun-nocsum": true, "--tun-csum": true, "--tun-remcsum": true}
+)
+
+// ParseIpvsadmRules reads rules in the format of `ipvsadm -Sn` and returns
+// them as model. Only -A (add service) and -a (add server) rules are
+// supported, addresses must be numeric. Empty lines and comments are skipped.
+func ParseIpvsadmRules(r io.Reader) (*IPVSConfig, error) {
+	res := NewIPVSConfig()
+	services := make(map[string]*Service)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := parseIpvsadmRule(strings.Fields(text))
+		if err != nil {
+			return nil, &IPVSImportError{line: line, what: err.Error()}
+		}
+
+		if rule.addService {
+			if _, ex := services[rule.service]; ex {
+				return nil, &IPVSImportError{line: line, what: "duplicate service " + rule.service}
+			}
+			s, err := serviceFromIpvsadmRule(rule)
+			if err != nil {
+				return nil, &IPVSImportError{line: line, what: err.Error()}
+			}
+			services[rule.service] = s
+			res.Services = append(res.Services, s)
+			continue
+		}
+
+		s, ex := services[rule.service]
+		if !ex {
+			return nil, &IPVSImportError{line: line, what: "server added to unknown service " + rule.service}
+		}
+		d, err := destinationFromIpvsadmRule(rule, serviceAddressFamily(s))
+		if err != nil {
+			return nil, &IPVSImportError{line: line, what: err.Error()}
+		}
+		s.Destinations = append(s.Destinations, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &IPVSImportError{what: err.Error()}
+	}
+
+	return res, nil
+}
+
+func parseIpvsadmRule(args []string) (*ipvsadmRule, error) {
+	rule := &ipvsadmRule{opts: make(map[string]string)}
+	command := ""
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		value := ""
+		hasValue := false
+		if strings.HasPrefix(arg, "--") {
+			if i := strings.Index(arg, "="); i != -1 {
+				arg, value, hasValue = arg[:i], arg[i+1:], true
+			}
+		}
+
+		o, ex := ipvsadmOptions[arg]
+		if !ex {
+			return nil, fmt.Errorf("unsupported option %s", arg)
+		}
+		switch o.arg {
+		case argRequired:
+			if !hasValue {
+				if idx+1 >= len(args) {
+					return nil, fmt.Errorf("missing value for option %s", arg)
+				}
+				idx++
+				value = args[idx]
+			}
+		case argOptional:
+			if !hasValue && idx+1 < len(args) && !strings.HasPrefix(args[idx+1], "-") {
+				idx++
+				value = args[idx]
+			}
+		default:
+			if hasValue {
+				return nil, fmt.Errorf("option %s does not take a value", arg)
+			}
+		}
+
+		switch o.name {
+		case "-A", "-a":
+			if command != "" {
+				return nil, fmt.Errorf("more than one command given")
+			}
+			command = o.name
+		case "-t", "-u", "--sctp-service", "-f":
+			if rule.service != "" {
+				return nil, fmt.Errorf("more than one service given")
+			}
+			handle, err := ipvsadmServiceHandle(o.name, value)
+			if err != nil {
+				return nil, err
+			}
+			rule.service = handle
+		case "-6":
+			rule.ipv6 = true
+		default:
+			if _, dup := rule.opts[o.name]; dup {
+				return nil, fmt.Errorf("option %s given twice", arg)
+			}
+			rule.opts[o.name] = value
+		}
+	}
+
+	if command == "" {
+		return nil, fmt.Errorf("only -A (add service) and -a (add server) rules are supported")
+	}
+	if rule.service == "" {
+		return nil, fmt.Errorf("missing service, one of -t, -u, --sctp-service or -f")
+	}
+	if rule.ipv6 && !strings.HasPrefix(rule.service, "fwmark:") {
+		return nil, fmt.Errorf("-6 is only valid for fwmark services")
+	}
+	if rule.ipv6 {
+		return nil, fmt.Errorf("IPv6 fwmark services are not supported")
+	}
+	rule.addService = command == "-A"
+
+	allowed := ipvsadmServiceOptions
+	if !rule.addService {
+		allowed = ipvsadmServerOptions
+	}
+	for name := range rule.opts {
+		if !allowed[name] {
+			return nil, fmt.Errorf("option %s is not valid for %s", name, command)
+		}
+	}
+
+	return rule, nil
+}
+
+// ipvsadmServiceHandle converts an ipvsadm service address into a model handle
+func ipvsadmServiceHandle(option, value string) (string, error) {
+	if option == "-f" {
+		fwmark, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || fwmark == 0 {
+			return "", fmt.Errorf("invalid fwmark %s", value)
+		}
+		return fmt.Sprintf("fwmark:%d", fwmark), nil
+	}
+
+	host, port, err := splitHostPort(value)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("address %s is not numeric, use ipvsadm -Sn", value)
+	}
+	if port < 0 || port > 65535 {
+		return "", fmt.Errorf("port out of range in %s", value)
+	}
+
+	proto := map[string]string{"-t": "tcp", "-u": "udp", "--sctp-service": "sctp"}[option]
+	return fmt.Sprintf("%s://%s", proto, joinHostPort(ip.String(), port)), nil
+}
+
+func serviceFromIpvsadmRule(rule *ipvsadmRule) (*Service, error) {
+	s := &Service{
+		Address:   rule.service,
+		SchedName: "wlc", // ipvsadm's default scheduler
+		PEName:    rule.opts["--pe"],
+	}
+	if sched, ex := rule.opts["-s"]; ex {
+		s.SchedName = sched
+	}
+
+	var flags uint32
+	if sf, ex := rule.opts["-b"]; ex {
+		var err error
+		flags, err = serviceFlagsFromStrings(strings.Split(sf, ","), s.SchedName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ex := rule.opts["-o"]; ex {
+		flags |= ipvs.SvcFlagOnePacket
+	}
+	s.Flags = serviceFlagsToStrings(flags, s.SchedName)
+
+	p, persistent := rule.opts["-p"]
+	if persistent {
+		timeout := uint32(IpvsadmDefaultPersistence)
+		if p != "" {
+			var err error
+			timeout, err = parseSeconds(p)
+			if err != nil || timeout == 0 {
+				return nil, fmt.Errorf("invalid persistence timeout %s", p)
+			}
+		}
+		s.Persistent = fmt.Sprintf("%ds", timeout)
+	}
+
+	if m, ex := rule.opts["-M"]; ex {
+		if !persistent {
+			return nil, fmt.Errorf("netmask requires a persistent service")
+		}
+		af := serviceAddressFamily(s)
+		ones, err := parsePersistenceNetmask(m, af)
+		if err != nil {
+			return nil, err
+		}
+		if ones != maxPrefixLen(af) {
+			s.PersistenceNetmask = formatPersistenceNetmask(af, ones)
+		}
+	}
+
+	return s, nil
+}
+
+func destinationFromIpvsadmRule(rule *ipvsadmRule, serviceFamily uint16) (*Destination, error) {
+	address, ex := rule.opts["-r"]
+	if !ex {
+		return nil, fmt.Errorf("missing real server, -r")
+	}
+	host, port, err := splitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("address %s is not numeric, use ipvsadm -Sn", address)
+	}
+
+	d := &Destination{
+		Address: joinHostPort(ip.String(), port),
+		Forward: "direct", // ipvsadm's default forwarding method
+		Weight:  1,
+	}
+
+	forwards := 0
+	for option, forward := range map[string]string{"-g": "direct", "-i": "tunnel", "-m": "nat"} {
+		if _, ex := rule.opts[option]; ex {
+			d.Forward = forward
+			forwards++
+		}
+	}
+	if forwards > 1 {
+		return nil, fmt.Errorf("more than one forwarding method given")
+	}
+	if d.Forward != "tunnel" && addressFamilyOf(ip) != serviceFamily {
+		return nil, fmt.Errorf("address family of %s differs from service, requires -i", address)
+	}
+
+	intOpt := func(option string, max int) (int, error) {
+		v, ex := rule.opts[option]
+		if !ex {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > max {
+			return 0, fmt.Errorf("invalid value %s for %s", v, option)
+		}
+		return n, nil
+	}
+	if _, ex := rule.opts["-w"]; ex {
+		if d.Weight, err = intOpt("-w", 65535); err != nil {
+			return nil, err
+		}
+	}
+	if d.MaxConnections, err = intOpt("-x", int(^uint32(0)>>1)); err != nil {
+		return nil, err
+	}
+	if d.MinConnections, err = intOpt("-y", int(^uint32(0)>>1)); err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{Type: rule.opts["--tun-type"]}
+	if t.Port, err = intOpt("--tun-port", 65535); err != nil {
+		return nil, err
+	}
+	for option, checksum := range map[string]string{"--tun-nocsum": "nocsum", "--tun-csum": "csum", "--tun-remcsum": "remcsum"} {
+		if _, ex := rule.opts[option]; ex {
+			if t.Checksum != "" {
+				return nil, fmt.Errorf("more than one tunnel checksum option given")
+			}
+			t.Checksum = checksum
+		}
+	}
+	if *t != (Tunnel{}) {
+		if d.Forward != "tunnel" {
+			return nil, fmt.Errorf("tunnel options require -i")
+		}
+		tt, tp, tf, err := tunnelToIpvs(t)
+		if err != nil {
+			return nil, err
+		}
+		d.Tunnel = tunnelFromIpvs(&ipvs.Destination{TunnelType: tt, TunnelPort: tp, TunnelFlags: tf})
+	}
+
+	return d, nil
+}
+
+// WriteIpvsadmRules writes all services and destinations as rules in the
+// format of `ipvsadm -Sn`, so they can be restored using `ipvsadm -R`.
+// Defaults of the model are applied. Sync daemons and timeouts are not
+// part of the rules.
+func (c *IPVSConfig) WriteIpvsadmRules(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, s := range c.Services {
+		svc, err := c.NewIpvsServiceStruct(s)
+		if err != nil {
+			return fmt.Errorf("unable to convert service %s: %w", s.Address, err)
+		}
+		handle := ipvsadmServiceOption(svc)
+
+		fmt.Fprintf(bw, "-A %s -s %s", handle, svc.SchedName)
+		if flags := serviceFlagsToStrings(svc.Flags&schedFlagsMask, svc.SchedName); len(flags) > 0 {
+			fmt.Fprintf(bw, " -b %s", strings.Join(flags, ","))
+		}
+		if svc.Flags&ipvs.SvcFlagPersistent != 0 {
+			fmt.Fprintf(bw, " -p %d", svc.Timeout)
+			if ones := netmaskFromIpvs(svc.AddressFamily, svc.Netmask); ones != maxPrefixLen(svc.AddressFamily) {
+				fmt.Fprintf(bw, " -M %s", formatPersistenceNetmask(svc.AddressFamily, ones))
+			}
+		}
+		if svc.PEName != "" {
+			fmt.Fprintf(bw, " --pe %s", svc.PEName)
+		}
+		if svc.Flags&ipvs.SvcFlagOnePacket != 0 {
+			fmt.Fprintf(bw, " -o")
+		}
+		fmt.Fprintln(bw)
+
+		for _, d := range s.Destinations {
+			dest, err := c.NewIpvsDestinationStruct(d)
+			if err != nil {
+				return fmt.Errorf("unable to convert destination %s of service %s: %w", d.Address, s.Address, err)
+			}
+			if dest.Port == 0 {
+				dest.Port = svc.Port
+			}
+
+			fmt.Fprintf(bw, "-a %s -r %s %s -w %d", handle, MakeAdressStringFromIpvsDestination(dest), ipvsadmForwardOption(dest), dest.Weight)
+			if dest.UpperThreshold != 0 {
+				fmt.Fprintf(bw, " -x %d", dest.UpperThreshold)
+			}
+			if dest.LowerThreshold != 0 {
+				fmt.Fprintf(bw, " -y %d", dest.LowerThreshold)
+			}
+			if t := tunnelFromIpvs(dest); t != nil && dest.ConnectionFlags == 0x2 {
+				fmt.Fprintf(bw, " --tun-type %s", t.Type)
+				if t.Port != 0 {
+					fmt.Fprintf(bw, " --tun-port %d", t.Port)
+				}
+				if t.Checksum != "" {
+					fmt.Fprintf(bw, " --tun-%s", t.Checksum)
+				}
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ipvsadmServiceOption formats the service option of ipvsadm, e.g. -t 10.0.0.1:80
+func ipvsadmServiceOption(svc *ipvs.Service) string {
+	if svc.FWMark != 0 {
+		return fmt.Sprintf("-f %d", svc.FWMark)
+	}
+	hostport := net.JoinHostPort(svc.Address.String(), strconv.Itoa(int(svc.Port)))
+	switch svc.Protocol {
+	case syscall.IPPROTO_UDP:
+		return "-u " + hostport
+	case syscall.IPPROTO_SCTP:
+		return "--sctp-service " + hostport
+	default:
+		return "-t " + hostport
+	}
+}
+
+// ipvsadmForwardOption formats the forwarding method of a destination
+func ipvsadmForwardOption(dest *ipvs.Destination) string {
+	switch dest.ConnectionFlags & ipvs.ConnectionFlagFwdMask {
+	case 0x2:
+		return "-i"
+	case 0x0:
+		return "-m"
+	default:
+		return "-g"
+	}
+}
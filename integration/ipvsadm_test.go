@@ -0,0 +1,123 @@
+package integration_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const ipvsadmRules = `-A -t 10.0.0.1:80 -s rr
+-a -t 10.0.0.1:80 -r 10.1.0.1:8080 -m -w 1
+-a -t 10.0.0.1:80 -r 10.1.0.2:8080 -m -w 50 -x 1000 -y 10
+-A -u 10.0.0.1:53 -s sh -b sh-fallback,sh-port -p 600 -M 255.255.255.0 -o
+-a -u 10.0.0.1:53 -r 10.1.0.3:53 -g -w 1
+-A -t [2001:db8::1]:443 -s wlc -p 300 -M 64
+-a -t [2001:db8::1]:443 -r 10.1.0.4:443 -i -w 1 --tun-type gue --tun-port 6080 --tun-csum
+-A -f 42 -s mh -b mh-port
+-a -f 42 -r 10.1.0.5:0 -g -w 0
+`
+
+func TestParseIpvsadmRules(t *testing.T) {
+	c, err := integration.ParseIpvsadmRules(strings.NewReader("# saved rules\n\n" + ipvsadmRules))
+	assert.Nil(t, err)
+	assert.Len(t, c.Services, 4)
+
+	b, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Equal(t, `services:
+- address: tcp://10.0.0.1:80
+  sched: rr
+  destinations:
+  - address: 10.1.0.1:8080
+    weight: 1
+    forward: nat
+  - address: 10.1.0.2:8080
+    weight: 50
+    forward: nat
+    max-connections: 1000
+    min-connections: 10
+- address: udp://10.0.0.1:53
+  sched: sh
+  persistent: 600s
+  persistence-netmask: 255.255.255.0
+  flags:
+  - one-packet
+  - sh-fallback
+  - sh-port
+  destinations:
+  - address: 10.1.0.3:53
+    weight: 1
+    forward: direct
+- address: tcp://[2001:db8::1]:443
+  sched: wlc
+  persistent: 300s
+  persistence-netmask: "64"
+  destinations:
+  - address: 10.1.0.4:443
+    weight: 1
+    forward: tunnel
+    tunnel:
+      type: gue
+      port: 6080
+      checksum: csum
+- address: fwmark:42
+  sched: mh
+  flags:
+  - mh-port
+  destinations:
+  - address: 10.1.0.5
+    forward: direct
+`, string(b))
+
+	// writing the rules again must result in the same dump
+	var out bytes.Buffer
+	assert.Nil(t, c.WriteIpvsadmRules(&out))
+	assert.Equal(t, ipvsadmRules, out.String())
+}
+
+func TestParseIpvsadmRulesDefaults(t *testing.T) {
+	c, err := integration.ParseIpvsadmRules(strings.NewReader(`
+--add-service --tcp-service=10.0.0.1:80 --persistent
+--add-server --tcp-service=10.0.0.1:80 --real-server=10.1.0.1:80
+`))
+	assert.Nil(t, err)
+	assert.Len(t, c.Services, 1)
+	s := c.Services[0]
+	assert.Equal(t, "wlc", s.SchedName)
+	assert.Equal(t, "300s", s.Persistent)
+	assert.Equal(t, "direct", s.Destinations[0].Forward)
+	assert.Equal(t, 1, s.Destinations[0].Weight)
+}
+
+func TestParseIpvsadmRulesErrors(t *testing.T) {
+	var tests = []struct {
+		rules string
+		line  int
+	}{
+		{"-A -t 10.0.0.1:80 -s rr\n-E -t 10.0.0.1:80 -s wrr", 2},
+		{"-A -t 10.0.0.1:80 -s rr\n-A -t 10.0.0.1:80 -s rr", 2},
+		{"-a -t 10.0.0.1:80 -r 10.1.0.1:80 -m", 1},
+		{"-A -t www.example.com:http -s rr", 1},
+		{"-A -t 10.0.0.1:80 -s rr -r 10.1.0.1:80", 1},
+		{"-A -t 10.0.0.1:80 -s rr -M 255.255.255.0", 1},
+		{"-A -t 10.0.0.1:80 -s rr -b sh-port", 1},
+		{"-A -f 1 -6 -s rr", 1},
+		{"-A -t 10.0.0.1:80 -s rr\n-a -t 10.0.0.1:80 -r [2001:db8::2]:80 -m", 2},
+		{"-A -t 10.0.0.1:80 -s rr\n-a -t 10.0.0.1:80 -r 10.1.0.1:80 -m -i", 2},
+		{"-A -t 10.0.0.1:80 -s rr\n-a -t 10.0.0.1:80 -r 10.1.0.1:80 -m --tun-type gue", 2},
+		{"-A -t 10.0.0.1:80 -s rr\n-a -t 10.0.0.1:80 -r 10.1.0.1:80 -w 70000", 2},
+		{"-A -t 10.0.0.1:80 -s", 1},
+	}
+
+	for _, test := range tests {
+		_, err := integration.ParseIpvsadmRules(strings.NewReader(test.rules))
+		importErr, ok := err.(*integration.IPVSImportError)
+		if assert.True(t, ok, "expected import error for %q, got %v", test.rules, err) {
+			assert.Equal(t, test.line, importErr.Line(), test.rules)
+		}
+	}
+}
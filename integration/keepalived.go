@@ -0,0 +1,558 @@
+package integration
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
+)
+
+// UnmappedConstruct describes a construct which could not be converted
+// between the model and the configuration of another tool
+type UnmappedConstruct struct {
+	Line      int    `yaml:"line,omitempty" json:"line,omitempty"` // line in the source file, 0 if not applicable
+	Construct string `yaml:"construct" json:"construct"`           // e.g. virtual_server 10.0.0.1 80: delay_loop
+}
+
+func (u UnmappedConstruct) String() string {
+	if u.Line == 0 {
+		return u.Construct
+	}
+	return fmt.Sprintf("line %d: %s", u.Line, u.Construct)
+}
+
+// keepalivedNode is a statement of keepalived.conf, with its
+// block if the statement opens one
+type keepalivedNode struct {
+	line     int
+	keyword  string
+	args     []string
+	children []*keepalivedNode // nil if the statement has no block
+}
+
+func (n *keepalivedNode) String() string {
+	return strings.Join(append([]string{n.keyword}, n.args...), " ")
+}
+
+// errorf returns an import error for the line of the statement
+func (n *keepalivedNode) errorf(format string, a ...interface{}) error {
+	return &IPVSImportError{line: n.line, what: fmt.Sprintf(format, a...)}
+}
+
+// keepalivedForwards maps keepalived's lb_kind to model forwards
+var keepalivedForwards = map[string]string{
+	"NAT": "nat",
+	"DR":  "direct",
+	"TUN": "tunnel",
+}
+
+// keepalivedServiceFlags contains the service flags keepalived accepts as
+// keywords of a virtual_server. They are named like model flags, except
+// for ops, which is an alias of one-packet.
+var keepalivedServiceFlags = map[string]bool{
+	"ops": true, "sh-port": true, "sh-fallback": true, "mh-port": true, "mh-fallback": true,
+	"flag-1": true, "flag-2": true, "flag-3": true,
+}
+
+// keepalivedFlagKeywords maps model flags to keepalived keywords of another name
+var keepalivedFlagKeywords = map[string]string{
+	"one-packet": "ops",
+}
+
+// ParseKeepalivedConfig reads the virtual_server and real_server blocks of a
+// keepalived.conf and returns them as model. All other blocks, health
+// checkers and statements which cannot be expressed in the model are
+// returned as unmapped constructs.
+func ParseKeepalivedConfig(r io.Reader) (*IPVSConfig, []UnmappedConstruct, error) {
+	nodes, err := parseKeepalivedNodes(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := NewIPVSConfig()
+	var unmapped []UnmappedConstruct
+	unmap := func(n *keepalivedNode, context string) {
+		construct := n.String()
+		if context != "" {
+			construct = context + ": " + construct
+		}
+		unmapped = append(unmapped, UnmappedConstruct{Line: n.line, Construct: construct})
+	}
+
+	for _, n := range nodes {
+		if n.keyword != "virtual_server" || n.children == nil || (len(n.args) > 0 && n.args[0] == "group") {
+			unmap(n, "")
+			continue
+		}
+
+		s, err := serviceFromKeepalived(n, unmap)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, other := range res.Services {
+			if other.Address == s.Address {
+				return nil, nil, &IPVSImportError{line: n.line, what: "duplicate virtual_server " + s.Address}
+			}
+		}
+		res.Services = append(res.Services, s)
+	}
+
+	return res, unmapped, nil
+}
+
+// parseKeepalivedNodes splits keepalived.conf into a tree of statements
+func parseKeepalivedNodes(r io.Reader) ([]*keepalivedNode, error) {
+	root := &keepalivedNode{}
+	stack := []*keepalivedNode{root}
+	var last *keepalivedNode // last statement, may open a block on the next line
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		tokens, err := splitKeepalivedLine(scanner.Text())
+		if err != nil {
+			return nil, &IPVSImportError{line: line, what: err.Error()}
+		}
+
+		for _, token := range tokens {
+			parent := stack[len(stack)-1]
+			switch token {
+			case "{":
+				if last == nil || last.children != nil {
+					return nil, &IPVSImportError{line: line, what: "block without statement"}
+				}
+				last.children = []*keepalivedNode{}
+				stack = append(stack, last)
+				last = nil
+			case "}":
+				if len(stack) == 1 {
+					return nil, &IPVSImportError{line: line, what: "unexpected }"}
+				}
+				stack = stack[:len(stack)-1]
+				last = nil
+			default:
+				if last != nil && last.line == line && last.children == nil {
+					last.args = append(last.args, token)
+					continue
+				}
+				last = &keepalivedNode{line: line, keyword: token}
+				parent.children = append(parent.children, last)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &IPVSImportError{what: err.Error()}
+	}
+	if len(stack) > 1 {
+		return nil, &IPVSImportError{line: stack[len(stack)-1].line, what: "block is not closed"}
+	}
+
+	return root.children, nil
+}
+
+// splitKeepalivedLine splits a line into words and braces, removing
+// comments. Quoted strings are returned without quotes.
+func splitKeepalivedLine(text string) ([]string, error) {
+	var res []string
+	var word strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			res = append(res, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			j := strings.IndexByte(text[i+1:], '"')
+			if j == -1 {
+				return nil, fmt.Errorf("unterminated quoted string")
+			}
+			word.WriteString(text[i+1 : i+1+j])
+			inWord = true
+			i += j + 1
+		case (c == '#' || c == '!') && !inWord:
+			flush()
+			return res, nil
+		case c == '{' || c == '}':
+			flush()
+			res = append(res, string(c))
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+
+	return res, nil
+}
+
+func serviceFromKeepalived(n *keepalivedNode, unmap func(*keepalivedNode, string)) (*Service, error) {
+	var host string
+	var port, fwmark int
+	switch {
+	case len(n.args) == 2 && n.args[0] == "fwmark":
+		f, err := strconv.ParseUint(n.args[1], 10, 32)
+		if err != nil || f == 0 {
+			return nil, n.errorf("invalid fwmark %s", n.args[1])
+		}
+		fwmark = int(f)
+	case len(n.args) == 2:
+		ip := net.ParseIP(strings.Trim(n.args[0], "[]"))
+		if ip == nil {
+			return nil, n.errorf("invalid virtual_server address %s", n.args[0])
+		}
+		p, err := strconv.ParseUint(n.args[1], 10, 16)
+		if err != nil {
+			return nil, n.errorf("invalid virtual_server port %s", n.args[1])
+		}
+		host, port = ip.String(), int(p)
+	default:
+		return nil, n.errorf("virtual_server must be given as <IP> <PORT> or fwmark <MARK>")
+	}
+	context := n.String()
+
+	s := &Service{}
+	protocol := "tcp"
+	forward := "nat" // keepalived's default lb_kind
+	var tunnel *Tunnel
+	var granularity *keepalivedNode
+	var flags []string
+	var realServers []*keepalivedNode
+
+	for _, c := range n.children {
+		if c.children != nil && c.keyword != "real_server" {
+			unmap(c, context)
+			continue
+		}
+		var err error
+		switch c.keyword {
+		case "lb_algo", "lvs_sched":
+			s.SchedName, err = keepalivedArg(c)
+		case "lb_kind", "lvs_method":
+			forward, tunnel, err = forwardFromKeepalived(c)
+		case "protocol":
+			var p string
+			p, err = keepalivedArg(c)
+			protocol = strings.ToLower(p)
+			if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+				err = fmt.Errorf("invalid protocol %s", p)
+			}
+		case "persistence_timeout":
+			var p string
+			if p, err = keepalivedArg(c); err == nil {
+				var secs uint32
+				if secs, err = parseSeconds(p); err == nil {
+					s.Persistent = fmt.Sprintf("%ds", secs)
+				}
+			}
+		case "persistence_granularity":
+			granularity = c
+		case "persistence_engine":
+			s.PEName, err = keepalivedArg(c)
+		case "real_server":
+			realServers = append(realServers, c)
+		default:
+			if keepalivedServiceFlags[c.keyword] && len(c.args) == 0 {
+				flags = append(flags, c.keyword)
+				continue
+			}
+			unmap(c, context)
+		}
+		if err != nil {
+			return nil, c.errorf("%s", err)
+		}
+	}
+
+	if fwmark != 0 {
+		s.Address = fmt.Sprintf("fwmark:%d", fwmark)
+	} else {
+		s.Address = fmt.Sprintf("%s://%s", protocol, joinHostPort(host, port))
+	}
+
+	if len(flags) > 0 {
+		bits, err := serviceFlagsFromStrings(flags, s.SchedName)
+		if err != nil {
+			return nil, n.errorf("%s", err)
+		}
+		s.Flags = serviceFlagsToStrings(bits, s.SchedName)
+	}
+
+	if granularity != nil {
+		if s.Persistent == "" {
+			unmap(granularity, context+" (without persistence_timeout)")
+		} else {
+			m, err := keepalivedArg(granularity)
+			if err != nil {
+				return nil, granularity.errorf("%s", err)
+			}
+			af := serviceAddressFamily(s)
+			ones, err := parsePersistenceNetmask(m, af)
+			if err != nil {
+				return nil, granularity.errorf("%s", err)
+			}
+			if ones != maxPrefixLen(af) {
+				s.PersistenceNetmask = formatPersistenceNetmask(af, ones)
+			}
+		}
+	}
+
+	for _, rs := range realServers {
+		d, err := destinationFromKeepalived(rs, forward, tunnel, unmap)
+		if err != nil {
+			return nil, err
+		}
+		s.Destinations = append(s.Destinations, d)
+	}
+
+	return s, nil
+}
+
+func destinationFromKeepalived(n *keepalivedNode, forward string, tunnel *Tunnel, unmap func(*keepalivedNode, string)) (*Destination, error) {
+	if len(n.args) < 1 || len(n.args) > 2 {
+		return nil, n.errorf("real_server must be given as <IP> [<PORT>]")
+	}
+	ip := net.ParseIP(strings.Trim(n.args[0], "[]"))
+	if ip == nil {
+		return nil, n.errorf("invalid real_server address %s", n.args[0])
+	}
+	port := 0
+	if len(n.args) == 2 {
+		p, err := strconv.ParseUint(n.args[1], 10, 16)
+		if err != nil {
+			return nil, n.errorf("invalid real_server port %s", n.args[1])
+		}
+		port = int(p)
+	}
+	context := n.String()
+
+	d := &Destination{
+		Address: joinHostPort(ip.String(), port),
+		Weight:  1, // keepalived's default weight
+	}
+
+	for _, c := range n.children {
+		if c.children != nil {
+			// health checkers, e.g. HTTP_GET or TCP_CHECK
+			unmap(c, context)
+			continue
+		}
+		var err error
+		switch c.keyword {
+		case "weight":
+			d.Weight, err = keepalivedIntArg(c, 65535)
+		case "uthreshold":
+			d.MaxConnections, err = keepalivedIntArg(c, int(^uint32(0)>>1))
+		case "lthreshold":
+			d.MinConnections, err = keepalivedIntArg(c, int(^uint32(0)>>1))
+		case "lb_kind", "lvs_method":
+			forward, tunnel, err = forwardFromKeepalived(c)
+		default:
+			unmap(c, context)
+		}
+		if err != nil {
+			return nil, c.errorf("%s", err)
+		}
+	}
+
+	d.Forward = forward
+	d.Tunnel = tunnel
+
+	return d, nil
+}
+
+// forwardFromKeepalived converts lb_kind into a model forward. Tunnels may
+// carry options, e.g. lb_kind TUN type gue port 6080 csum.
+func forwardFromKeepalived(n *keepalivedNode) (string, *Tunnel, error) {
+	if len(n.args) == 0 {
+		return "", nil, fmt.Errorf("missing value for %s", n.keyword)
+	}
+	forward, ex := keepalivedForwards[strings.ToUpper(n.args[0])]
+	if !ex {
+		return "", nil, fmt.Errorf("invalid %s %s, must be one of NAT, DR or TUN", n.keyword, n.args[0])
+	}
+	if forward != "tunnel" {
+		if len(n.args) > 1 {
+			return "", nil, fmt.Errorf("%s %s does not take options", n.keyword, n.args[0])
+		}
+		return forward, nil, nil
+	}
+
+	t := &Tunnel{}
+	for i := 1; i < len(n.args); i++ {
+		switch n.args[i] {
+		case "type", "port":
+			if i+1 >= len(n.args) {
+				return "", nil, fmt.Errorf("missing value for tunnel %s", n.args[i])
+			}
+			if n.args[i] == "type" {
+				t.Type = n.args[i+1]
+			} else {
+				p, err := strconv.ParseUint(n.args[i+1], 10, 16)
+				if err != nil {
+					return "", nil, fmt.Errorf("invalid tunnel port %s", n.args[i+1])
+				}
+				t.Port = int(p)
+			}
+			i++
+		case "nocsum", "csum", "remcsum":
+			t.Checksum = n.args[i]
+		default:
+			return "", nil, fmt.Errorf("invalid tunnel option %s", n.args[i])
+		}
+	}
+
+	tt, tp, tf, err := tunnelToIpvs(t)
+	if err != nil {
+		return "", nil, err
+	}
+	return forward, tunnelFromIpvs(&ipvs.Destination{TunnelType: tt, TunnelPort: tp, TunnelFlags: tf}), nil
+}
+
+func keepalivedArg(n *keepalivedNode) (string, error) {
+	if len(n.args) != 1 {
+		return "", fmt.Errorf("%s takes exactly one value", n.keyword)
+	}
+	return n.args[0], nil
+}
+
+func keepalivedIntArg(n *keepalivedNode, max int) (int, error) {
+	a, err := keepalivedArg(n)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(a)
+	if err != nil || v < 0 || v > max {
+		return 0, fmt.Errorf("invalid value %s for %s", a, n.keyword)
+	}
+	return v, nil
+}
+
+// WriteKeepalivedConfig writes all services and destinations as virtual_server
+// and real_server blocks of keepalived.conf. Defaults of the model are applied.
+// Sync daemons and timeouts are returned as unmapped constructs.
+func (c *IPVSConfig) WriteKeepalivedConfig(w io.Writer) ([]UnmappedConstruct, error) {
+	var unmapped []UnmappedConstruct
+	bw := bufio.NewWriter(w)
+
+	for idx, s := range c.Services {
+		svc, err := c.NewIpvsServiceStruct(s)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert service %s: %w", s.Address, err)
+		}
+		dests, err := c.NewIpvsDestinationsStruct(s)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert destinations of service %s: %w", s.Address, err)
+		}
+
+		if idx > 0 {
+			fmt.Fprintln(bw)
+		}
+		if svc.FWMark != 0 {
+			fmt.Fprintf(bw, "virtual_server fwmark %d {\n", svc.FWMark)
+		} else {
+			fmt.Fprintf(bw, "virtual_server %s %d {\n", svc.Address, svc.Port)
+		}
+		fmt.Fprintf(bw, "    lb_algo %s\n", svc.SchedName)
+
+		// lb_kind is given for the virtual_server if all real servers share it
+		kinds := make(map[string]bool)
+		for _, d := range dests {
+			kinds[keepalivedLbKind(d)] = true
+		}
+		common := ""
+		if len(kinds) == 1 {
+			for k := range kinds {
+				common = k
+			}
+			fmt.Fprintf(bw, "    lb_kind %s\n", common)
+		}
+
+		if svc.FWMark == 0 {
+			fmt.Fprintf(bw, "    protocol %s\n", strings.ToUpper(protoNumToStr(svc)))
+		}
+		if svc.Flags&ipvs.SvcFlagPersistent != 0 {
+			fmt.Fprintf(bw, "    persistence_timeout %d\n", svc.Timeout)
+			if ones := netmaskFromIpvs(svc.AddressFamily, svc.Netmask); ones != maxPrefixLen(svc.AddressFamily) {
+				fmt.Fprintf(bw, "    persistence_granularity %s\n", formatPersistenceNetmask(svc.AddressFamily, ones))
+			}
+		}
+		if svc.PEName != "" {
+			fmt.Fprintf(bw, "    persistence_engine %s\n", svc.PEName)
+		}
+		for _, f := range serviceFlagsToStrings(svc.Flags&serviceFlagsMask, svc.SchedName) {
+			if k, ok := keepalivedFlagKeywords[f]; ok {
+				f = k
+			}
+			fmt.Fprintf(bw, "    %s\n", f)
+		}
+
+		for _, d := range dests {
+			fmt.Fprintln(bw)
+			if d.Port == 0 {
+				fmt.Fprintf(bw, "    real_server %s {\n", d.Address)
+			} else {
+				fmt.Fprintf(bw, "    real_server %s %d {\n", d.Address, d.Port)
+			}
+			fmt.Fprintf(bw, "        weight %d\n", d.Weight)
+			if k := keepalivedLbKind(d); k != common {
+				fmt.Fprintf(bw, "        lb_kind %s\n", k)
+			}
+			if d.UpperThreshold != 0 {
+				fmt.Fprintf(bw, "        uthreshold %d\n", d.UpperThreshold)
+			}
+			if d.LowerThreshold != 0 {
+				fmt.Fprintf(bw, "        lthreshold %d\n", d.LowerThreshold)
+			}
+			fmt.Fprintf(bw, "    }\n")
+		}
+		fmt.Fprintf(bw, "}\n")
+	}
+
+	for _, sd := range c.Sync {
+		unmapped = append(unmapped, UnmappedConstruct{
+			Construct: fmt.Sprintf("sync daemon %s on %s, requires lvs_sync_daemon with a vrrp_instance", sd.State, sd.Interface),
+		})
+	}
+	if c.Timeouts != nil && *c.Timeouts != (Timeouts{}) {
+		unmapped = append(unmapped, UnmappedConstruct{
+			Construct: "timeouts, requires lvs_timeouts in global_defs",
+		})
+	}
+
+	return unmapped, bw.Flush()
+}
+
+// keepalivedLbKind formats the forward of a destination as lb_kind,
+// including tunnel options
+func keepalivedLbKind(d *ipvs.Destination) string {
+	switch getForward(d) {
+	case "nat":
+		return "NAT"
+	case "direct":
+		return "DR"
+	}
+
+	kind := "TUN"
+	if t := tunnelFromIpvs(d); t != nil {
+		kind += " type " + t.Type
+		if t.Port != 0 {
+			kind += fmt.Sprintf(" port %d", t.Port)
+		}
+		if t.Checksum != "" {
+			kind += " " + t.Checksum
+		}
+	}
+	return kind
+}
@@ -0,0 +1,203 @@
+package integration_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const keepalivedConf = `! Configuration File for keepalived
+global_defs {
+    router_id LVS_A
+}
+
+vrrp_instance VI_1 {
+    state MASTER
+    interface eth0
+    virtual_router_id 51
+}
+
+virtual_server 10.0.0.1 80 {
+    delay_loop 6
+    lb_algo wrr
+    lb_kind DR
+    persistence_timeout 300
+    persistence_granularity 255.255.255.0
+    protocol TCP
+
+    real_server 10.1.0.1 80 {
+        weight 100
+        uthreshold 1000
+        TCP_CHECK {
+            connect_timeout 3
+        }
+    }
+    real_server 10.1.0.2 80 {
+        weight 50
+        lb_kind NAT
+        inhibit_on_failure
+    }
+}
+
+virtual_server fwmark 42 {
+    lb_algo sh
+    sh-port
+    real_server 10.1.0.3 {
+    }
+}
+
+virtual_server 2001:db8::1 443 {
+    lb_algo rr
+    lb_kind TUN type gue port 6080 csum
+    protocol UDP
+    ops
+    real_server 10.1.0.4 443 {
+        lthreshold 10
+    }
+}
+`
+
+func TestParseKeepalivedConfig(t *testing.T) {
+	c, unmapped, err := integration.ParseKeepalivedConfig(strings.NewReader(keepalivedConf))
+	assert.Nil(t, err)
+
+	b, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Equal(t, `services:
+- address: tcp://10.0.0.1:80
+  sched: wrr
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+  destinations:
+  - address: 10.1.0.1:80
+    weight: 100
+    forward: direct
+    max-connections: 1000
+  - address: 10.1.0.2:80
+    weight: 50
+    forward: nat
+- address: fwmark:42
+  sched: sh
+  flags:
+  - sh-port
+  destinations:
+  - address: 10.1.0.3
+    weight: 1
+    forward: nat
+- address: udp://[2001:db8::1]:443
+  sched: rr
+  flags:
+  - one-packet
+  destinations:
+  - address: 10.1.0.4:443
+    weight: 1
+    forward: tunnel
+    min-connections: 10
+    tunnel:
+      type: gue
+      port: 6080
+      checksum: csum
+`, string(b))
+
+	var constructs []string
+	for _, u := range unmapped {
+		constructs = append(constructs, u.String())
+	}
+	assert.Equal(t, []string{
+		"line 2: global_defs",
+		"line 6: vrrp_instance VI_1",
+		"line 13: virtual_server 10.0.0.1 80: delay_loop 6",
+		"line 23: real_server 10.1.0.1 80: TCP_CHECK",
+		"line 30: real_server 10.1.0.2 80: inhibit_on_failure",
+	}, constructs)
+}
+
+func TestWriteKeepalivedConfig(t *testing.T) {
+	c, _, err := integration.ParseKeepalivedConfig(strings.NewReader(keepalivedConf))
+	assert.Nil(t, err)
+	c.Timeouts = &integration.Timeouts{TCP: "15m"}
+
+	var out bytes.Buffer
+	unmapped, err := c.WriteKeepalivedConfig(&out)
+	assert.Nil(t, err)
+	assert.Len(t, unmapped, 1)
+	assert.Equal(t, `virtual_server 10.0.0.1 80 {
+    lb_algo wrr
+    protocol TCP
+    persistence_timeout 300
+    persistence_granularity 255.255.255.0
+
+    real_server 10.1.0.1 80 {
+        weight 100
+        lb_kind DR
+        uthreshold 1000
+    }
+
+    real_server 10.1.0.2 80 {
+        weight 50
+        lb_kind NAT
+    }
+}
+
+virtual_server fwmark 42 {
+    lb_algo sh
+    lb_kind NAT
+    sh-port
+
+    real_server 10.1.0.3 {
+        weight 1
+    }
+}
+
+virtual_server 2001:db8::1 443 {
+    lb_algo rr
+    lb_kind TUN type gue port 6080 csum
+    protocol UDP
+    ops
+
+    real_server 10.1.0.4 443 {
+        weight 1
+        lthreshold 10
+    }
+}
+`, out.String())
+
+	// the written configuration must result in the same model
+	c2, unmapped, err := integration.ParseKeepalivedConfig(&out)
+	assert.Nil(t, err)
+	assert.Len(t, unmapped, 0)
+	c.Timeouts = nil
+	y1, _ := yaml.Marshal(c)
+	y2, _ := yaml.Marshal(c2)
+	assert.Equal(t, string(y1), string(y2))
+}
+
+func TestParseKeepalivedConfigErrors(t *testing.T) {
+	var tests = []struct {
+		conf string
+		line int
+	}{
+		{"virtual_server 10.0.0.1 80 {\n  lb_algo rr\n", 1},
+		{"virtual_server 10.0.0.1 80 {\n}\n}", 3},
+		{"virtual_server www.example.com 80 {\n}", 1},
+		{"virtual_server 10.0.0.1 80 {\n  lb_kind MASQ\n}", 2},
+		{"virtual_server 10.0.0.1 80 {\n  protocol ICMP\n}", 2},
+		{"virtual_server 10.0.0.1 80 {\n  real_server 10.1.0.1 80 {\n    weight -1\n  }\n}", 3},
+		{"virtual_server 10.0.0.1 80 {\n  lb_algo rr\n  sh-port\n}", 1},
+		{"virtual_server 10.0.0.1 80 {\n}\nvirtual_server 10.0.0.1 80 {\n}", 3},
+		{"virtual_server 10.0.0.1 80 {\n  lb_kind TUN type vxlan\n}", 2},
+		{"router_id \"LVS\n", 1},
+	}
+
+	for _, test := range tests {
+		_, _, err := integration.ParseKeepalivedConfig(strings.NewReader(test.conf))
+		importErr, ok := err.(*integration.IPVSImportError)
+		if assert.True(t, ok, "expected import error for %q, got %v", test.conf, err) {
+			assert.Equal(t, test.line, importErr.Line(), test.conf)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package integration
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"path/filepath"
 
 	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
 
@@ -13,44 +17,93 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // Service describes an IPVS service entry
 type Service struct {
-	Address      string         `yaml:"address"`
-	SchedName    string         `yaml:"sched,omitempty"`
-	Destinations []*Destination `yaml:"destinations,omitempty"`
+	Address            string         `yaml:"address" json:"address"`
+	SchedName          string         `yaml:"sched,omitempty" json:"sched,omitempty"`
+	Persistent         string         `yaml:"persistent,omitempty" json:"persistent,omitempty"`                   // persistence timeout as duration, e.g. 300s
+	PersistenceNetmask string         `yaml:"persistence-netmask,omitempty" json:"persistence-netmask,omitempty"` // netmask or prefix length for persistence
+	Flags              []string       `yaml:"flags,omitempty" json:"flags,omitempty"`                             // scheduler flags, e.g. sh-port, ops
+	PEName             string         `yaml:"pe,omitempty" json:"pe,omitempty"`                                   // persistence engine, e.g. sip
+	Destinations       []*Destination `yaml:"destinations,omitempty" json:"destinations,omitempty"`
 
 	service *ipvs.Service // underlay from ipvs package
 }
 
 // Destination models a real server behind a service
 type Destination struct {
-	Address string `yaml:"address"`
-	Weight  int    `yaml:"weight,omitempty"`  // weight for weighted forwarders
-	Forward string `yaml:"forward,omitempty"` // forwards as string (direct, tunnel, nat)
+	Address        string  `yaml:"address" json:"address"`
+	Weight         int     `yaml:"weight,omitempty" json:"weight,omitempty"`                   // weight for weighted forwarders
+	Forward        string  `yaml:"forward,omitempty" json:"forward,omitempty"`                 // forwards as string (direct, tunnel, nat)
+	MaxConnections int     `yaml:"max-connections,omitempty" json:"max-connections,omitempty"` // upper connection threshold, 0=unlimited
+	MinConnections int     `yaml:"min-connections,omitempty" json:"min-connections,omitempty"` // lower connection threshold
+	Tunnel         *Tunnel `yaml:"tunnel,omitempty" json:"tunnel,omitempty"`                   // encapsulation for tunnel forwarding
 
 	destination *ipvs.Destination // underlay from ipvs package
 }
 
+// Tunnel describes the encapsulation of a tunnel-forwarded destination
+type Tunnel struct {
+	Type     string `yaml:"type,omitempty" json:"type,omitempty"`         // ipip (default), gue or gre
+	Port     int    `yaml:"port,omitempty" json:"port,omitempty"`         // udp port, required for gue
+	Checksum string `yaml:"checksum,omitempty" json:"checksum,omitempty"` // nocsum (default), csum or remcsum
+}
+
 // Defaults contains default values for various model elements. If set here they can be
 // omitted in Services or Destinations
 type Defaults struct {
-	Port      *int    `yaml:"port,omitempty"`    // default port
-	Weight    *int    `yaml:"weight,omitempty"`  // default weight for weighted forwarders
-	SchedName *string `yaml:"sched,omitempty"`   // default scheduler
-	Forward   *string `yaml:"forward,omitempty"` // default forwards as string (direct, tunnel, nat)
+	Port               *int    `yaml:"port,omitempty" json:"port,omitempty"`                               // default port
+	Weight             *int    `yaml:"weight,omitempty" json:"weight,omitempty"`                           // default weight for weighted forwarders
+	SchedName          *string `yaml:"sched,omitempty" json:"sched,omitempty"`                             // default scheduler
+	Forward            *string `yaml:"forward,omitempty" json:"forward,omitempty"`                         // default forwards as string (direct, tunnel, nat)
+	Persistent         *string `yaml:"persistent,omitempty" json:"persistent,omitempty"`                   // default persistence timeout
+	PersistenceNetmask *string `yaml:"persistence-netmask,omitempty" json:"persistence-netmask,omitempty"` // default netmask or prefix length for persistence
+	MaxConnections     *int    `yaml:"max-connections,omitempty" json:"max-connections,omitempty"`         // default upper connection threshold
+	MinConnections     *int    `yaml:"min-connections,omitempty" json:"min-connections,omitempty"`         // default lower connection threshold
+}
+
+// SyncDaemon describes an IPVS connection synchronisation daemon
+type SyncDaemon struct {
+	State     string `yaml:"state" json:"state"`                         // master or backup
+	Interface string `yaml:"interface" json:"interface"`                 // multicast interface
+	SyncID    int    `yaml:"sync-id,omitempty" json:"sync-id,omitempty"` // sync id, 0..255
+	Group     string `yaml:"group,omitempty" json:"group,omitempty"`     // multicast group, defaults to 224.0.0.81
+	Port      int    `yaml:"port,omitempty" json:"port,omitempty"`       // multicast port, defaults to 8848
+	TTL       int    `yaml:"ttl,omitempty" json:"ttl,omitempty"`         // multicast ttl, defaults to 1
+
+	daemon *ipvs.Daemon // underlay from ipvs package
+}
+
+// Timeouts contains the global ipvs connection timeouts as durations,
+// e.g. 15m. Omitted timeouts are left unchanged.
+type Timeouts struct {
+	TCP    string `yaml:"tcp,omitempty" json:"tcp,omitempty"`       // timeout of established tcp connections
+	TCPFin string `yaml:"tcpfin,omitempty" json:"tcpfin,omitempty"` // timeout of tcp connections after receiving a FIN
+	UDP    string `yaml:"udp,omitempty" json:"udp,omitempty"`       // timeout of udp packets
 }
 
 // IPVSConfig is a single ipvs setup
 type IPVSConfig struct {
-	Defaults Defaults   `yaml:"defaults,omitempty"`
-	Services []*Service `yaml:"services,omitempty"`
+	Defaults Defaults      `yaml:"defaults,omitempty" json:"defaults,omitzero"` // json omits structs by omitzero only
+	Services []*Service    `yaml:"services,omitempty" json:"services,omitempty"`
+	Sync     []*SyncDaemon `yaml:"sync,omitempty" json:"sync,omitempty"`         // nil leaves sync daemons untouched
+	Timeouts *Timeouts     `yaml:"timeouts,omitempty" json:"timeouts,omitempty"` // nil leaves timeouts untouched
 
 	//
-	log *log.Logger
+	log          *log.Logger
+	namespace    string             // network namespace path, empty for the current one
+	backend      ipvs.Backend       // backend to use instead of a kernel handle, may be nil
+	parallelism  int                // number of parallel destination queries in Get, 0 for default
+	timing       GetTiming          // timing of the last Get
+	capabilities *ipvs.Capabilities // kernel capabilities used by Validate, may be nil
 }
 
+// NetnsDir is the directory where named network namespaces are located
+const NetnsDir = "/var/run/netns"
+
 // NewIPVSConfig creates a new IPVS configuration object with a default logger
 func NewIPVSConfig() *IPVSConfig {
 	return NewIPVSConfigWithLogger(log.New(ioutil.Discard, "ipvsctl: ", log.Lshortfile))
@@ -66,13 +119,117 @@ func NewIPVSConfigWithLogger(l *log.Logger) *IPVSConfig {
 // From creates a new IPSConfig from an existing one
 func From(c *IPVSConfig) *IPVSConfig {
 	return &IPVSConfig{
-		log: c.log,
+		log:          c.log,
+		namespace:    c.namespace,
+		backend:      c.backend,
+		parallelism:  c.parallelism,
+		capabilities: c.capabilities,
 	}
 }
 
+// WithNamespace sets the network namespace in which all ipvs tables are
+// read and written. ns is either a path (e.g. /proc/1234/ns/net) or the name
+// of a namespace in NetnsDir. An empty ns denotes the current namespace.
+func (c *IPVSConfig) WithNamespace(ns string) *IPVSConfig {
+	if ns != "" && !strings.Contains(ns, "/") {
+		ns = filepath.Join(NetnsDir, ns)
+	}
+	c.namespace = ns
+	return c
+}
+
+// Namespace returns the path of the network namespace, or an empty string
+// for the current one
+func (c *IPVSConfig) Namespace() string {
+	return c.namespace
+}
+
+// WithBackend sets the backend on which all ipvs tables are read and written,
+// e.g. an ipvs.Memory for testing. It is not closed by ipvsctl. A nil backend
+// denotes a kernel handle in the configured namespace.
+func (c *IPVSConfig) WithBackend(b ipvs.Backend) *IPVSConfig {
+	c.backend = b
+	return c
+}
+
+// WithCapabilities makes Validate reject models which cannot run on a kernel with
+// the given capabilities, e.g. the result of ipvs.Probe. A nil caps disables these checks.
+func (c *IPVSConfig) WithCapabilities(caps *ipvs.Capabilities) *IPVSConfig {
+	c.capabilities = caps
+	return c
+}
+
+// WithParallelism sets the maximum number of destination queries Get runs in
+// parallel, each on its own handle. n <= 0 resets to DefaultParallelism.
+func (c *IPVSConfig) WithParallelism(n int) *IPVSConfig {
+	c.parallelism = n
+	return c
+}
+
+// Backend returns the backend set by WithBackend, or nil
+func (c *IPVSConfig) Backend() ipvs.Backend {
+	return c.backend
+}
+
+// sharedBackend wraps a backend given by WithBackend, so that it is
+// not closed after an operation
+type sharedBackend struct {
+	ipvs.Backend
+}
+
+func (sharedBackend) Close() {
+}
+
+// newHandle returns the configured backend, or creates an ipvs handle
+// within the configured namespace
+func (c *IPVSConfig) newHandle() (ipvs.Backend, error) {
+	if c.backend != nil {
+		return sharedBackend{c.backend}, nil
+	}
+	h, err := ipvs.New(c.namespace)
+	if err != nil {
+		c.log.Printf("Unable to create ipvs handle in namespace '%s': %s\n", c.namespace, err)
+		return nil, err
+	}
+	return h, nil
+}
+
 // ChangeSet contains a number of change set items
 type ChangeSet struct {
-	Items []interface{} `yaml:"items,omitempty"`
+	Items []interface{} `yaml:"items,omitempty" json:"items,omitempty"`
+}
+
+// changeSetDocument is the serialized form of a ChangeSet. It is used
+// to decode items as ChangeSetItem instead of generic maps.
+type changeSetDocument struct {
+	Items []ChangeSetItem `yaml:"items,omitempty" json:"items,omitempty"`
+}
+
+func (cs *ChangeSet) setItems(items []ChangeSetItem) {
+	cs.Items = nil
+	for _, item := range items {
+		cs.Items = append(cs.Items, item)
+	}
+}
+
+// UnmarshalYAML decodes a change set with all items as ChangeSetItem
+func (cs *ChangeSet) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var doc changeSetDocument
+	if err := unmarshal(&doc); err != nil {
+		return err
+	}
+	cs.setItems(doc.Items)
+	return nil
+}
+
+// UnmarshalJSON decodes a change set with all items as ChangeSetItem
+func (cs *ChangeSet) UnmarshalJSON(b []byte) error {
+	var doc changeSetDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	cs.setItems(doc.Items)
+	return nil
 }
 
 // ChangeSetItemType as type for const names of types of change set items
@@ -96,14 +253,28 @@ const (
 
 	// DeleteDestination deletes an existing destination
 	DeleteDestination ChangeSetItemType = "delete-destination"
+
+	// AddSyncDaemon starts a new connection sync daemon
+	AddSyncDaemon ChangeSetItemType = "add-sync-daemon"
+
+	// UpdateSyncDaemon restarts an existing connection sync daemon with new settings
+	UpdateSyncDaemon ChangeSetItemType = "update-sync-daemon"
+
+	// DeleteSyncDaemon stops an existing connection sync daemon
+	DeleteSyncDaemon ChangeSetItemType = "delete-sync-daemon"
+
+	// UpdateTimeouts sets global connection timeouts
+	UpdateTimeouts ChangeSetItemType = "update-timeouts"
 )
 
 // ChangeSetItem ...
 type ChangeSetItem struct {
-	Type        ChangeSetItemType `yaml:"type"`
-	Description string
-	Service     *Service     `yaml:"service,omitempty"`
-	Destination *Destination `yaml:"destination,omitempty"`
+	Type        ChangeSetItemType `yaml:"type" json:"type"`
+	Description string            `yaml:"description" json:"description"`
+	Service     *Service          `yaml:"service,omitempty" json:"service,omitempty"`
+	Destination *Destination      `yaml:"destination,omitempty" json:"destination,omitempty"`
+	SyncDaemon  *SyncDaemon       `yaml:"sync,omitempty" json:"sync,omitempty"`
+	Timeouts    *Timeouts         `yaml:"timeouts,omitempty" json:"timeouts,omitempty"`
 }
 
 // ApplyActionType is a mapped string to some action for the apply function
@@ -130,6 +301,12 @@ const (
 
 	// ApplyActionDeleteDestination allows for deleting of existing destinations
 	ApplyActionDeleteDestination ApplyActionType = "dd"
+
+	// ApplyActionSyncDaemon allows for starting, restarting and stopping of sync daemons
+	ApplyActionSyncDaemon ApplyActionType = "sy"
+
+	// ApplyActionUpdateTimeouts allows for updates of global connection timeouts
+	ApplyActionUpdateTimeouts ApplyActionType = "ut"
 )
 
 // AllApplyActions provides the ApplyActions with all actions enabled
@@ -141,6 +318,8 @@ func AllApplyActions() ApplyActions {
 		ApplyActionAddDestination:    true,
 		ApplyActionUpdateDestination: true,
 		ApplyActionDeleteDestination: true,
+		ApplyActionSyncDaemon:        true,
+		ApplyActionUpdateTimeouts:    true,
 	}
 }
 
@@ -177,7 +356,26 @@ func splitProtoHostPort(in string) (proto, hostport string, err error) {
 }
 
 func splitHostPort(in string) (host string, port int, err error) {
-	// 1. todo: check for ipv6 addr e.g. [fe80]
+	// bracketed ipv6 address, e.g. [2001:db8::1] or [2001:db8::1]:80
+	if strings.HasPrefix(in, "[") {
+		i := strings.Index(in, "]")
+		if i == -1 {
+			return "", 0, errors.New("missing ']' in " + in)
+		}
+		host = in[1:i]
+		rest := in[i+1:]
+		if rest == "" {
+			return host, 0, nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", 0, errors.New("parse error in " + in)
+		}
+		p, err := strconv.ParseInt(rest[1:], 10, 32)
+		if err != nil {
+			return "", 0, err
+		}
+		return host, int(p), nil
+	}
 
 	i := strings.LastIndex(in, ":")
 	if i == -1 {
@@ -187,6 +385,10 @@ func splitHostPort(in string) (host string, port int, err error) {
 
 	a := strings.Split(in, ":")
 	if len(a) != 2 {
+		// unbracketed ipv6 address cannot carry a port
+		if ip := net.ParseIP(in); ip != nil {
+			return in, 0, nil
+		}
 		return "", 0, errors.New("parse error in " + in)
 	}
 	p, err := strconv.ParseInt(a[1], 10, 32)
@@ -197,6 +399,163 @@ func splitHostPort(in string) (host string, port int, err error) {
 	return a[0], int(p), nil
 }
 
+// joinHostPort formats host and port as used in model addresses. IPv6
+// addresses are put in brackets. A port of 0 is omitted.
+func joinHostPort(host string, port int) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port == 0 {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// isSameHost compares two host parts of addresses. If both are valid
+// ip addresses, they are compared as such so that different notations
+// of the same ipv6 address are treated as equal.
+func isSameHost(a, b string) bool {
+	aip := net.ParseIP(a)
+	bip := net.ParseIP(b)
+	if aip != nil && bip != nil {
+		return aip.Equal(bip)
+	}
+	return a == b
+}
+
+// addressFamilyOf returns the address family (AF_INET or AF_INET6) of ip.
+func addressFamilyOf(ip net.IP) uint16 {
+	if ip != nil && ip.To4() == nil {
+		return syscall.AF_INET6
+	}
+	return syscall.AF_INET
+}
+
+// maxPrefixLen returns the number of bits of an address within the given address family
+func maxPrefixLen(af uint16) int {
+	if af == syscall.AF_INET6 {
+		return 128
+	}
+	return 32
+}
+
+// netmaskToIpvs converts a prefix length into the netmask representation of
+// the kernel. For IPv4 this is the mask in network byte order, for IPv6 the
+// prefix length itself.
+func netmaskToIpvs(af uint16, ones int) uint32 {
+	if af == syscall.AF_INET6 {
+		return uint32(ones)
+	}
+	return binary.NativeEndian.Uint32(net.CIDRMask(ones, 32))
+}
+
+// netmaskFromIpvs converts a netmask as given by the kernel into a prefix length.
+func netmaskFromIpvs(af uint16, netmask uint32) int {
+	if af == syscall.AF_INET6 {
+		return int(netmask)
+	}
+	m := make(net.IPMask, 4)
+	binary.NativeEndian.PutUint32(m, netmask)
+	ones, _ := m.Size()
+	return ones
+}
+
+// parseSeconds parses a timeout, given as duration string or number
+// of seconds, into seconds. Durations must be whole seconds.
+func parseSeconds(in string) (uint32, error) {
+	secs, err := strconv.ParseUint(in, 10, 32)
+	if err == nil {
+		return uint32(secs), nil
+	}
+	d, err := time.ParseDuration(in)
+	if err != nil {
+		return 0, errors.New("unable to parse duration " + in)
+	}
+	if d < 0 || d.Seconds() > float64(^uint32(0)) {
+		return 0, errors.New("duration out of range: " + in)
+	}
+	if d%time.Second != 0 {
+		return 0, errors.New("duration must be given in whole seconds: " + in)
+	}
+	return uint32(d / time.Second), nil
+}
+
+// parsePersistenceNetmask parses a netmask (e.g. 255.255.255.0) or a prefix
+// length (e.g. 24) into a prefix length for the given address family.
+func parsePersistenceNetmask(in string, af uint16) (int, error) {
+	maxLen := maxPrefixLen(af)
+	if strings.Contains(in, ".") {
+		ip := net.ParseIP(in).To4()
+		if ip == nil || af != syscall.AF_INET {
+			return 0, errors.New("invalid netmask " + in)
+		}
+		ones, bits := net.IPMask(ip).Size()
+		if bits == 0 {
+			return 0, errors.New("non-contiguous netmask " + in)
+		}
+		return ones, nil
+	}
+	ones, err := strconv.Atoi(strings.TrimPrefix(in, "/"))
+	if err != nil || ones < 1 || ones > maxLen {
+		return 0, fmt.Errorf("invalid prefix length %s, must be within 1..%d", in, maxLen)
+	}
+	return ones, nil
+}
+
+// formatPersistenceNetmask formats a prefix length for the model, as dotted
+// netmask for IPv4 and as prefix length for IPv6
+func formatPersistenceNetmask(af uint16, ones int) string {
+	if af == syscall.AF_INET6 {
+		return strconv.Itoa(ones)
+	}
+	return net.IP(net.CIDRMask(ones, 32)).String()
+}
+
+// serviceAddressFamily returns the address family of a service address. Fwmark
+// services and unparsable addresses are treated as IPv4.
+func serviceAddressFamily(s *Service) uint16 {
+	_, host, _, _, err := splitCompoundAddress(s.Address)
+	if err != nil {
+		return syscall.AF_INET
+	}
+	return addressFamilyOf(net.ParseIP(host))
+}
+
+// servicePersistence returns the effective persistence timeout in seconds
+// (0 if not persistent) and the persistence netmask as prefix length of
+// service s, including defaults.
+func (c *IPVSConfig) servicePersistence(s *Service) (uint32, int, error) {
+	af := serviceAddressFamily(s)
+
+	p := s.Persistent
+	if p == "" && c.Defaults.Persistent != nil {
+		p = *c.Defaults.Persistent
+	}
+	var timeout uint32
+	if p != "" {
+		var err error
+		timeout, err = parseSeconds(p)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	ones := maxPrefixLen(af)
+	m := s.PersistenceNetmask
+	if m == "" && c.Defaults.PersistenceNetmask != nil {
+		m = *c.Defaults.PersistenceNetmask
+	}
+	if m != "" && timeout > 0 {
+		var err error
+		ones, err = parsePersistenceNetmask(m, af)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return timeout, ones, nil
+}
+
 func splitCompoundAddress(in string) (protocol, addressPart string, port, fwmark int, err error) {
 	if strings.HasPrefix(in, "fwmark:") {
 		// treat rest as fwmark integer
@@ -259,15 +618,32 @@ func (c *IPVSConfig) NewIpvsServiceStruct(s *Service) (*ipvs.Service, error) {
 		}
 	}
 
+	ip := net.ParseIP(host)
+	af := addressFamilyOf(ip)
+
+	timeout, ones, err := c.servicePersistence(s)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := serviceFlagsFromStrings(s.Flags, schedName)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		flags |= ipvs.SvcFlagPersistent
+	}
+
 	res := &ipvs.Service{
 		Protocol:      protoAsNum,
-		Address:       net.ParseIP(host),
+		Address:       ip,
 		Port:          uint16(port),
 		FWMark:        uint32(fwmark),
-		AddressFamily: syscall.AF_INET,
+		AddressFamily: af,
 		SchedName:     schedName,
-		PEName:        "",
-		Netmask:       0xffffffff,
+		Flags:         flags,
+		Timeout:       timeout,
+		PEName:        s.PEName,
+		Netmask:       netmaskToIpvs(af, ones),
 	}
 
 	return res, nil
@@ -331,14 +707,203 @@ func (c *IPVSConfig) NewIpvsDestinationStruct(destination *Destination) (*ipvs.D
 	if w < 0 || w > 65535 {
 		w = 1
 	}
+
+	upper, lower := c.destinationThresholds(destination)
+	if upper < 0 || lower < 0 {
+		return nil, errors.New("connection threshold out of range")
+	}
+
+	tt, tp, tf, err := tunnelToIpvs(destination.Tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(h)
+
 	return &ipvs.Destination{
-		Address:         net.ParseIP(h),
+		Address:         ip,
+		AddressFamily:   addressFamilyOf(ip),
 		Port:            uint16(p),
 		ConnectionFlags: cf,
 		Weight:          w,
+		UpperThreshold:  uint32(upper),
+		LowerThreshold:  uint32(lower),
+		TunnelType:      tt,
+		TunnelPort:      tp,
+		TunnelFlags:     tf,
 	}, nil
 }
 
+// tunnelToIpvs converts a model tunnel into type, port and flags of an
+// ipvs.Destination. A nil tunnel denotes plain ipip.
+func tunnelToIpvs(t *Tunnel) (uint8, uint16, uint16, error) {
+	if t == nil {
+		return ipvs.TunnelTypeIPIP, 0, 0, nil
+	}
+
+	var tt uint8
+	switch t.Type {
+	case "", "ipip":
+		tt = ipvs.TunnelTypeIPIP
+	case "gue":
+		tt = ipvs.TunnelTypeGUE
+	case "gre":
+		tt = ipvs.TunnelTypeGRE
+	default:
+		return 0, 0, 0, errors.New("bad tunnel type. Must be one of ipip, gue or gre")
+	}
+
+	var tf uint16
+	switch t.Checksum {
+	case "", "nocsum":
+		tf = ipvs.TunnelFlagNoCsum
+	case "csum":
+		tf = ipvs.TunnelFlagCsum
+	case "remcsum":
+		tf = ipvs.TunnelFlagRemCsum
+	default:
+		return 0, 0, 0, errors.New("bad tunnel checksum. Must be one of nocsum, csum or remcsum")
+	}
+
+	if t.Port < 0 || t.Port > 65535 {
+		return 0, 0, 0, errors.New("tunnel port out of range")
+	}
+
+	return tt, uint16(t.Port), tf, nil
+}
+
+// tunnelFromIpvs creates a model tunnel from an ipvs.Destination. It
+// returns nil for plain ipip tunnels.
+func tunnelFromIpvs(d *ipvs.Destination) *Tunnel {
+	if d.TunnelType == ipvs.TunnelTypeIPIP && d.TunnelPort == 0 && d.TunnelFlags == 0 {
+		return nil
+	}
+
+	t := &Tunnel{Port: int(d.TunnelPort)}
+	switch d.TunnelType {
+	case ipvs.TunnelTypeIPIP:
+		t.Type = "ipip"
+	case ipvs.TunnelTypeGUE:
+		t.Type = "gue"
+	case ipvs.TunnelTypeGRE:
+		t.Type = "gre"
+	default:
+		t.Type = "?"
+	}
+	switch {
+	case d.TunnelFlags&ipvs.TunnelFlagRemCsum != 0:
+		t.Checksum = "remcsum"
+	case d.TunnelFlags&ipvs.TunnelFlagCsum != 0:
+		t.Checksum = "csum"
+	}
+
+	return t
+}
+
+// destinationThresholds returns the effective upper and lower connection
+// thresholds of destination d, including defaults.
+func (c *IPVSConfig) destinationThresholds(d *Destination) (upper, lower int) {
+	upper = d.MaxConnections
+	if upper == 0 && c.Defaults.MaxConnections != nil {
+		upper = *c.Defaults.MaxConnections
+	}
+	lower = d.MinConnections
+	if lower == 0 && c.Defaults.MinConnections != nil {
+		lower = *c.Defaults.MinConnections
+	}
+	return upper, lower
+}
+
+// serviceFlag describes a model name of a service flag. If sched is
+// not empty, the flag is only valid for this scheduler.
+type serviceFlag struct {
+	name  string
+	bit   uint32
+	sched string
+}
+
+var serviceFlags = []serviceFlag{
+	{"one-packet", ipvs.SvcFlagOnePacket, ""},
+	{"sh-fallback", ipvs.SvcFlagSched1, "sh"},
+	{"sh-port", ipvs.SvcFlagSched2, "sh"},
+	{"mh-fallback", ipvs.SvcFlagSched1, "mh"},
+	{"mh-port", ipvs.SvcFlagSched2, "mh"},
+	{"flag-1", ipvs.SvcFlagSched1, ""},
+	{"flag-2", ipvs.SvcFlagSched2, ""},
+	{"flag-3", ipvs.SvcFlagSched3, ""},
+}
+
+// serviceFlagAliases maps alternative model names of service flags to their names
+var serviceFlagAliases = map[string]string{
+	"ops": "one-packet",
+}
+
+// schedFlagsMask covers all scheduler specific flags
+const schedFlagsMask = ipvs.SvcFlagSched1 | ipvs.SvcFlagSched2 | ipvs.SvcFlagSched3
+
+// unflaggedSchedNames contains schedulers which are known to not support any scheduler flags
+var unflaggedSchedNames = []string{"fo", "ovf", "twos"}
+
+// serviceFlagsMask covers all flags which can be set via the model
+const serviceFlagsMask = ipvs.SvcFlagOnePacket | ipvs.SvcFlagSched1 | ipvs.SvcFlagSched2 | ipvs.SvcFlagSched3
+
+// serviceFlagsFromStrings converts model flag names into flag bits. Scheduler
+// specific names must match the given scheduler.
+func serviceFlagsFromStrings(flags []string, sched string) (uint32, error) {
+	var res uint32
+
+	for _, f := range flags {
+		name := f
+		if alias, ok := serviceFlagAliases[f]; ok {
+			name = alias
+		}
+		found := false
+		for _, sf := range serviceFlags {
+			if sf.name == name {
+				if sf.sched != "" && sf.sched != sched {
+					return 0, fmt.Errorf("flag %s requires scheduler %s", f, sf.sched)
+				}
+				res |= sf.bit
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, errors.New("unknown flag " + f)
+		}
+	}
+
+	if res&schedFlagsMask != 0 {
+		for _, sn := range unflaggedSchedNames {
+			if sn == sched {
+				return 0, fmt.Errorf("scheduler %s does not support scheduler flags", sched)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// serviceFlagsToStrings converts flag bits into model flag names, using
+// scheduler specific names where available.
+func serviceFlagsToStrings(flags uint32, sched string) []string {
+	var res []string
+	var done uint32
+
+	for _, sf := range serviceFlags {
+		if flags&sf.bit == 0 || done&sf.bit != 0 {
+			continue
+		}
+		if sf.sched != "" && sf.sched != sched {
+			continue
+		}
+		res = append(res, sf.name)
+		done |= sf.bit
+	}
+
+	return res
+}
+
 // CompareServicesEquality for Service does a complete compare. it applies config defaults
 func CompareServicesEquality(ca *IPVSConfig, a *Service, cb *IPVSConfig, b *Service) (bool, error) {
 	var err error
@@ -370,6 +935,40 @@ func CompareServicesEquality(ca *IPVSConfig, a *Service, cb *IPVSConfig, b *Serv
 		return false, nil
 	}
 
+	// compare persistence
+	at, am, err := ca.servicePersistence(a)
+	if err != nil {
+		return false, err
+	}
+	bt, bm, err := cb.servicePersistence(b)
+	if err != nil {
+		return false, err
+	}
+	if at != bt {
+		return false, nil
+	}
+	if at > 0 && am != bm {
+		return false, nil
+	}
+
+	// compare persistence engine
+	if a.PEName != b.PEName {
+		return false, nil
+	}
+
+	// compare flags
+	aflags, err := serviceFlagsFromStrings(a.Flags, af)
+	if err != nil {
+		return false, err
+	}
+	bflags, err := serviceFlagsFromStrings(b.Flags, bf)
+	if err != nil {
+		return false, err
+	}
+	if aflags != bflags {
+		return false, nil
+	}
+
 	// everything is equal
 	return true, nil
 }
@@ -400,7 +999,7 @@ func CompareServicesIdentifyingEquality(ca *IPVSConfig, a *Service, cb *IPVSConf
 	if apr != bpr {
 		return false, nil
 	}
-	if ah != bh {
+	if !isSameHost(ah, bh) {
 		return false, nil
 	}
 	if ap != bp {
@@ -432,7 +1031,7 @@ func CompareDestinationsEquality(ca *IPVSConfig, a *Destination, cb *IPVSConfig,
 		bp = *cb.Defaults.Port
 	}
 
-	if ah != bh {
+	if !isSameHost(ah, bh) {
 		return false, nil
 	}
 	if ap != bp {
@@ -452,6 +1051,26 @@ func CompareDestinationsEquality(ca *IPVSConfig, a *Destination, cb *IPVSConfig,
 		return false, nil
 	}
 
+	// compare thresholds
+	aupper, alower := ca.destinationThresholds(a)
+	bupper, blower := cb.destinationThresholds(b)
+	if aupper != bupper || alower != blower {
+		return false, nil
+	}
+
+	// compare tunnel encapsulation
+	att, atp, atf, err := tunnelToIpvs(a.Tunnel)
+	if err != nil {
+		return false, err
+	}
+	btt, btp, btf, err := tunnelToIpvs(b.Tunnel)
+	if err != nil {
+		return false, err
+	}
+	if att != btt || atp != btp || atf != btf {
+		return false, nil
+	}
+
 	if !opts.KeepWeights {
 		// compare weight
 		aw := a.Weight
@@ -500,7 +1119,7 @@ func CompareDestinationIdentifyingEquality(ca *IPVSConfig, a *Destination, cb *I
 		bp = *cb.Defaults.Port
 	}
 
-	if ah != bh {
+	if !isSameHost(ah, bh) {
 		return false, nil
 	}
 	if ap != bp {
@@ -510,11 +1129,164 @@ func CompareDestinationIdentifyingEquality(ca *IPVSConfig, a *Destination, cb *I
 	return true, nil
 }
 
+const (
+	defaultSyncGroup = "224.0.0.81"
+	defaultSyncPort  = 8848
+	defaultSyncTTL   = 1
+)
+
+func syncStateFromString(state string) (uint32, error) {
+	switch state {
+	case "master":
+		return ipvs.DaemonStateMaster, nil
+	case "backup":
+		return ipvs.DaemonStateBackup, nil
+	default:
+		return 0, errors.New("bad sync daemon state. Must be one of master or backup")
+	}
+}
+
+func syncStateToString(state uint32) string {
+	switch state {
+	case ipvs.DaemonStateMaster:
+		return "master"
+	case ipvs.DaemonStateBackup:
+		return "backup"
+	default:
+		return "?"
+	}
+}
+
+// NewIpvsDaemonStruct creates a new ipvs.Daemon struct from model integration.SyncDaemon
+func (c *IPVSConfig) NewIpvsDaemonStruct(sd *SyncDaemon) (*ipvs.Daemon, error) {
+	state, err := syncStateFromString(sd.State)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ipvs.Daemon{
+		State:    state,
+		McastIfn: sd.Interface,
+		SyncID:   uint32(sd.SyncID),
+		McastTTL: uint8(sd.TTL),
+	}
+	if sd.Group != "" {
+		res.McastGroup = net.ParseIP(sd.Group)
+		if res.McastGroup == nil {
+			return nil, errors.New("bad multicast group " + sd.Group)
+		}
+	}
+	if sd.Port < 0 || sd.Port > 65535 {
+		return nil, errors.New("port out of range")
+	}
+	res.McastPort = uint16(sd.Port)
+
+	return res, nil
+}
+
+// CompareSyncDaemonsEquality compares two sync daemons including their settings.
+// Omitted group, port and ttl values are treated as kernel defaults.
+func CompareSyncDaemonsEquality(a, b *SyncDaemon) bool {
+	if a.State != b.State || a.Interface != b.Interface || a.SyncID != b.SyncID {
+		return false
+	}
+
+	ag, bg := a.Group, b.Group
+	if ag == "" {
+		ag = defaultSyncGroup
+	}
+	if bg == "" {
+		bg = defaultSyncGroup
+	}
+	if !isSameHost(ag, bg) {
+		return false
+	}
+
+	ap, bp := a.Port, b.Port
+	if ap == 0 {
+		ap = defaultSyncPort
+	}
+	if bp == 0 {
+		bp = defaultSyncPort
+	}
+	if ap != bp {
+		return false
+	}
+
+	at, bt := a.TTL, b.TTL
+	if at == 0 {
+		at = defaultSyncTTL
+	}
+	if bt == 0 {
+		bt = defaultSyncTTL
+	}
+	return at == bt
+}
+
+// NewIpvsConfigStruct creates a new ipvs.Config struct from model integration.Timeouts.
+// Omitted timeouts are 0, which leaves them unchanged.
+func (c *IPVSConfig) NewIpvsConfigStruct(t *Timeouts) (*ipvs.Config, error) {
+	res := &ipvs.Config{}
+
+	for _, x := range []struct {
+		in  string
+		out *time.Duration
+	}{
+		{t.TCP, &res.TimeoutTCP},
+		{t.TCPFin, &res.TimeoutTCPFin},
+		{t.UDP, &res.TimeoutUDP},
+	} {
+		if x.in == "" {
+			continue
+		}
+		secs, err := parseSeconds(x.in)
+		if err != nil {
+			return nil, err
+		}
+		*x.out = time.Duration(secs) * time.Second
+	}
+
+	return res, nil
+}
+
+// CompareTimeoutsEquality compares the timeouts given in b against a.
+// Timeouts omitted in b are treated as equal.
+func CompareTimeoutsEquality(a, b *Timeouts) (bool, error) {
+	for _, x := range []struct{ a, b string }{
+		{a.TCP, b.TCP},
+		{a.TCPFin, b.TCPFin},
+		{a.UDP, b.UDP},
+	} {
+		if x.b == "" {
+			continue
+		}
+		if x.a == "" {
+			return false, nil
+		}
+		as, err := parseSeconds(x.a)
+		if err != nil {
+			return false, err
+		}
+		bs, err := parseSeconds(x.b)
+		if err != nil {
+			return false, err
+		}
+		if as != bs {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
 // LocateServiceAndDestination returns a Service and Destination by their names
 func (c *IPVSConfig) LocateServiceAndDestination(serviceHandle, destinationHandle string) (*Service, *Destination) {
 	var s *Service
 	var d *Destination
 
+	serviceHandle = normalizeServiceHandle(serviceHandle)
+	destinationHandle = normalizeDestinationHandle(destinationHandle)
+
 	for _, service := range c.Services {
 		if service.service == nil {
 			continue
@@ -542,3 +1314,35 @@ func (c *IPVSConfig) LocateServiceAndDestination(serviceHandle, destinationHandl
 
 	return s, d
 }
+
+// normalizeServiceHandle brings a service handle into the form produced by
+// MakeAdressStringFromIpvsService, e.g. so that different notations of the same
+// ipv6 address match. Handles which cannot be parsed are returned unchanged.
+func normalizeServiceHandle(handle string) string {
+	proto, host, port, fwmark, err := splitCompoundAddress(handle)
+	if err != nil {
+		return handle
+	}
+	if fwmark != 0 {
+		return fmt.Sprintf("fwmark:%d", fwmark)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return handle
+	}
+	return fmt.Sprintf("%s://%s", proto, joinHostPort(ip.String(), port))
+}
+
+// normalizeDestinationHandle brings a destination handle into the form produced by
+// MakeAdressStringFromIpvsDestination. Handles which cannot be parsed are returned unchanged.
+func normalizeDestinationHandle(handle string) string {
+	host, port, err := splitHostPort(handle)
+	if err != nil {
+		return handle
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return handle
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
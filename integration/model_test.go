@@ -1,7 +1,13 @@
 package integration
 
 import (
+	"encoding/json"
+	"strings"
+	"syscall"
 	"testing"
+
+	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
+	"gopkg.in/yaml.v2"
 )
 
 func TestSplitProtoHostPort(t *testing.T) {
@@ -38,6 +44,9 @@ func TestSplitHostPort(t *testing.T) {
 		{"1.2.3.4", "1.2.3.4", 0},
 		{"1.2.3.4:80", "1.2.3.4", 80},
 		{"some.host:443", "some.host", 443},
+		{"[2001:db8::1]:80", "2001:db8::1", 80},
+		{"[2001:db8::1]", "2001:db8::1", 0},
+		{"2001:db8::1", "2001:db8::1", 0},
 	}
 
 	for _, table := range tables {
@@ -63,6 +72,7 @@ func TestSplitCompoundAddress(t *testing.T) {
 		{"fwmark:37", "", "", 0, 37},
 		{"tcp://1.2.3.4:80/", "tcp", "1.2.3.4", 80, 0},
 		{"tcp://some.host/", "tcp", "some.host", 0, 0},
+		{"udp://[2001:db8::1]:53", "udp", "2001:db8::1", 53, 0},
 	}
 	for _, table := range tables {
 		p, ap, port, fwmark, err := splitCompoundAddress(table.in)
@@ -83,7 +93,7 @@ func TestSplitCompoundAddress(t *testing.T) {
 		}
 	}
 
-	var invalidIns = []string{"fwmark:abc", "nosuchproto://1.2.3"}
+	var invalidIns = []string{"fwmark:abc", "nosuchproto://1.2.3", "tcp://[2001:db8::1:80", "tcp://[2001:db8::1]80"}
 	for _, in := range invalidIns {
 		_, _, _, _, err := splitCompoundAddress(in)
 		if err == nil {
@@ -92,3 +102,195 @@ func TestSplitCompoundAddress(t *testing.T) {
 
 	}
 }
+
+func TestNormalizeHandles(t *testing.T) {
+	tables := []struct {
+		in, service, destination string
+	}{
+		{"tcp://10.0.0.1:80", "tcp://10.0.0.1:80", "tcp://10.0.0.1:80"},
+		{"tcp://[2001:0db8:0::1]:80", "tcp://[2001:db8::1]:80", "tcp://[2001:0db8:0::1]:80"},
+		{"fwmark:12", "fwmark:12", "fwmark:12"},
+		{"10.0.0.2:8080", "10.0.0.2:8080", "10.0.0.2:8080"},
+		{"[2001:db8:0:0::2]:8080", "[2001:db8:0:0::2]:8080", "[2001:db8::2]:8080"},
+	}
+	for _, table := range tables {
+		if s := normalizeServiceHandle(table.in); s != table.service {
+			t.Errorf("Service handle was incorrect: %s", s)
+		}
+		if d := normalizeDestinationHandle(table.in); d != table.destination {
+			t.Errorf("Destination handle was incorrect: %s", d)
+		}
+	}
+}
+
+func TestNewIpvsServiceStructPersistence(t *testing.T) {
+	c := NewIPVSConfig()
+
+	s, err := c.NewIpvsServiceStruct(&Service{
+		Address:            "tcp://10.0.0.1:443",
+		Persistent:         "5m",
+		PersistenceNetmask: "255.255.255.0",
+	})
+	if err != nil {
+		t.Errorf("Internal error occurred: %s", err)
+	}
+	if s.Flags&ipvs.SvcFlagPersistent == 0 {
+		t.Errorf("Persistent flag not set: %x", s.Flags)
+	}
+	if s.Timeout != 300 {
+		t.Errorf("Timeout was incorrect: %d", s.Timeout)
+	}
+	if ones := netmaskFromIpvs(s.AddressFamily, s.Netmask); ones != 24 {
+		t.Errorf("Netmask was incorrect: %d", ones)
+	}
+
+	s, err = c.NewIpvsServiceStruct(&Service{
+		Address: "tcp://[2001:db8::1]:443",
+	})
+	if err != nil {
+		t.Errorf("Internal error occurred: %s", err)
+	}
+	if s.Flags != 0 || s.Timeout != 0 {
+		t.Errorf("Service must not be persistent: %x, %d", s.Flags, s.Timeout)
+	}
+	if s.Netmask != 128 {
+		t.Errorf("Netmask was incorrect: %d", s.Netmask)
+	}
+}
+
+func TestServiceFlags(t *testing.T) {
+	tables := []struct {
+		in    []string
+		sched string
+		bits  uint32
+		out   []string
+	}{
+		{[]string{"sh-fallback", "sh-port", "ops"}, "sh", ipvs.SvcFlagSched1 | ipvs.SvcFlagSched2 | ipvs.SvcFlagOnePacket, []string{"one-packet", "sh-fallback", "sh-port"}},
+		{[]string{"mh-port"}, "mh", ipvs.SvcFlagSched2, []string{"mh-port"}},
+		{[]string{"one-packet", "flag-3"}, "rr", ipvs.SvcFlagOnePacket | ipvs.SvcFlagSched3, []string{"one-packet", "flag-3"}},
+		{nil, "rr", 0, nil},
+	}
+	for _, table := range tables {
+		bits, err := serviceFlagsFromStrings(table.in, table.sched)
+		if err != nil {
+			t.Errorf("Internal error occurred: %s", err)
+		}
+		if bits != table.bits {
+			t.Errorf("Flags were incorrect: %x", bits)
+		}
+		out := serviceFlagsToStrings(bits, table.sched)
+		if len(out) != len(table.out) {
+			t.Errorf("Flag names were incorrect: %v", out)
+			continue
+		}
+		for idx := range out {
+			if out[idx] != table.out[idx] {
+				t.Errorf("Flag names were incorrect: %v", out)
+			}
+		}
+	}
+
+	if _, err := serviceFlagsFromStrings([]string{"sh-port"}, "wrr"); err == nil {
+		t.Errorf("should have produced an error, but did not")
+	}
+}
+
+func TestNewIpvsDestinationStructAddressFamily(t *testing.T) {
+	c := NewIPVSConfig()
+
+	tables := []struct {
+		in string
+		af uint16
+	}{
+		{"10.0.0.1:80", syscall.AF_INET},
+		{"[2001:db8::1]:80", syscall.AF_INET6},
+	}
+	for _, table := range tables {
+		d, err := c.NewIpvsDestinationStruct(&Destination{Address: table.in, Forward: "tunnel"})
+		if err != nil {
+			t.Errorf("Internal error occurred: %s", err)
+		}
+		if d.AddressFamily != table.af {
+			t.Errorf("AddressFamily was incorrect: %d", d.AddressFamily)
+		}
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	tables := []struct {
+		in, path string
+	}{
+		{"", ""},
+		{"blue", "/var/run/netns/blue"},
+		{"/proc/1234/ns/net", "/proc/1234/ns/net"},
+	}
+	for _, table := range tables {
+		c := NewIPVSConfig().WithNamespace(table.in)
+		if c.Namespace() != table.path {
+			t.Errorf("Namespace was incorrect: %s", c.Namespace())
+		}
+		if From(c).Namespace() != table.path {
+			t.Errorf("Namespace was not copied: %s", From(c).Namespace())
+		}
+	}
+}
+
+func TestModelJSONRoundTrip(t *testing.T) {
+	model := `
+defaults:
+  port: 8080
+  sched: wrr
+  persistent: 300s
+services:
+- address: tcp://10.0.0.1:80
+  flags:
+  - sh-port
+  pe: sip
+  destinations:
+  - address: 10.1.0.1
+    weight: 100
+    forward: tunnel
+    max-connections: 1000
+    tunnel:
+      type: gue
+      port: 6080
+sync:
+- state: master
+  interface: eth0
+  sync-id: 7
+timeouts:
+  tcpfin: 2m
+`
+	var fromYAML IPVSConfig
+	if err := yaml.Unmarshal([]byte(model), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(&fromYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON IPVSConfig
+	if err := json.Unmarshal(b, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	y1, _ := yaml.Marshal(&fromYAML)
+	y2, _ := yaml.Marshal(&fromJSON)
+	if string(y1) != string(y2) {
+		t.Errorf("model changed in json round trip.\nbefore:\n%s\nafter:\n%s\njson: %s", y1, y2, b)
+	}
+	if !strings.Contains(string(b), `"defaults":{"port":8080,`) {
+		t.Errorf("defaults missing in json: %s", b)
+	}
+
+	// empty defaults are omitted like in yaml
+	fromYAML.Defaults = Defaults{}
+	b, err = json.Marshal(&fromYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"defaults"`) {
+		t.Errorf("empty defaults must be omitted in json: %s", b)
+	}
+}
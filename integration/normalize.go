@@ -0,0 +1,153 @@
+package integration
+
+// Normalize returns a copy of ipvsconfig with all defaults applied: Values
+// missing in services and destinations are taken from the defaults section
+// or, for scheduler (rr) and forward (direct), from the built-in defaults.
+// Default ports are added to addresses, sync daemons get the kernel defaults
+// for multicast group, port and ttl. The defaults section is kept, so
+// the copy describes the same configuration, e.g. the default port of
+// fwmark services. ipvsconfig itself is not modified. Normalize expects a
+// valid model, elements which cannot be parsed are copied unchanged.
+func (ipvsconfig *IPVSConfig) Normalize() *IPVSConfig {
+	res := From(ipvsconfig)
+	res.Defaults = ipvsconfig.Defaults.copy()
+
+	if ipvsconfig.Sync != nil {
+		res.Sync = make([]*SyncDaemon, len(ipvsconfig.Sync))
+		for idx, sd := range ipvsconfig.Sync {
+			c := *sd
+			if c.Group == "" {
+				c.Group = defaultSyncGroup
+			}
+			if c.Port == 0 {
+				c.Port = defaultSyncPort
+			}
+			if c.TTL == 0 {
+				c.TTL = defaultSyncTTL
+			}
+			res.Sync[idx] = &c
+		}
+	}
+	if ipvsconfig.Timeouts != nil {
+		t := *ipvsconfig.Timeouts
+		res.Timeouts = &t
+	}
+
+	res.Services = make([]*Service, len(ipvsconfig.Services))
+	for idx, service := range ipvsconfig.Services {
+		res.Services[idx] = ipvsconfig.normalizeService(service)
+	}
+
+	return res
+}
+
+func (ipvsconfig *IPVSConfig) normalizeService(service *Service) *Service {
+	res := &Service{
+		Address:            service.Address,
+		SchedName:          service.SchedName,
+		Persistent:         service.Persistent,
+		PersistenceNetmask: service.PersistenceNetmask,
+		PEName:             service.PEName,
+		service:            service.service,
+	}
+	if service.Flags != nil {
+		res.Flags = append([]string{}, service.Flags...)
+	}
+
+	proto, host, port, fwmark, err := splitCompoundAddress(service.Address)
+	if err == nil && fwmark == 0 && port == 0 && ipvsconfig.Defaults.Port != nil {
+		res.Address = proto + "://" + joinHostPort(host, *ipvsconfig.Defaults.Port)
+	}
+
+	if res.SchedName == "" {
+		res.SchedName = "rr"
+		if ipvsconfig.Defaults.SchedName != nil && *ipvsconfig.Defaults.SchedName != "" {
+			res.SchedName = *ipvsconfig.Defaults.SchedName
+		}
+	}
+	if res.Persistent == "" && ipvsconfig.Defaults.Persistent != nil {
+		res.Persistent = *ipvsconfig.Defaults.Persistent
+	}
+	// a persistence netmask without persistence is ignored, see servicePersistence
+	if res.PersistenceNetmask == "" && res.Persistent != "" && ipvsconfig.Defaults.PersistenceNetmask != nil {
+		res.PersistenceNetmask = *ipvsconfig.Defaults.PersistenceNetmask
+	}
+
+	if service.Destinations != nil {
+		res.Destinations = make([]*Destination, len(service.Destinations))
+		for idx, destination := range service.Destinations {
+			res.Destinations[idx] = ipvsconfig.normalizeDestination(destination)
+		}
+	}
+
+	return res
+}
+
+func (ipvsconfig *IPVSConfig) normalizeDestination(destination *Destination) *Destination {
+	res := &Destination{
+		Address:     destination.Address,
+		Forward:     destination.Forward,
+		Weight:      destination.Weight,
+		destination: destination.destination,
+	}
+	if destination.Tunnel != nil {
+		t := *destination.Tunnel
+		res.Tunnel = &t
+	}
+	res.MaxConnections, res.MinConnections = ipvsconfig.destinationThresholds(destination)
+
+	host, port, err := splitHostPort(destination.Address)
+	if err == nil && port == 0 && ipvsconfig.Defaults.Port != nil {
+		res.Address = joinHostPort(host, *ipvsconfig.Defaults.Port)
+	}
+
+	if res.Forward == "" {
+		res.Forward = "direct"
+		if ipvsconfig.Defaults.Forward != nil && *ipvsconfig.Defaults.Forward != "" {
+			res.Forward = *ipvsconfig.Defaults.Forward
+		}
+	}
+	if res.Weight == 0 && ipvsconfig.Defaults.Weight != nil {
+		res.Weight = *ipvsconfig.Defaults.Weight
+	}
+
+	return res
+}
+
+// copy returns a deep copy of d
+func (d Defaults) copy() Defaults {
+	res := Defaults{}
+	if d.Port != nil {
+		v := *d.Port
+		res.Port = &v
+	}
+	if d.Weight != nil {
+		v := *d.Weight
+		res.Weight = &v
+	}
+	if d.SchedName != nil {
+		v := *d.SchedName
+		res.SchedName = &v
+	}
+	if d.Forward != nil {
+		v := *d.Forward
+		res.Forward = &v
+	}
+	if d.Persistent != nil {
+		v := *d.Persistent
+		res.Persistent = &v
+	}
+	if d.PersistenceNetmask != nil {
+		v := *d.PersistenceNetmask
+		res.PersistenceNetmask = &v
+	}
+	if d.MaxConnections != nil {
+		v := *d.MaxConnections
+		res.MaxConnections = &v
+	}
+	if d.MinConnections != nil {
+		v := *d.MinConnections
+		res.MinConnections = &v
+	}
+	return res
+}
@@ -0,0 +1,155 @@
+package integration_test
+
+import (
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const normalizeModel = `defaults:
+  port: 8080
+  weight: 100
+  sched: wrr
+  forward: tunnel
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+  max-connections: 1000
+services:
+- address: tcp://10.0.0.1
+  destinations:
+  - address: '[2001:db8::10]'
+    tunnel:
+      type: gue
+      port: 6080
+  - address: 10.1.0.2:80
+    weight: 50
+    forward: nat
+    min-connections: 10
+- address: fwmark:42
+  sched: sh
+  persistent: 60s
+  flags:
+  - sh-port
+- address: udp://10.0.0.1:53
+sync:
+- state: master
+  interface: eth0
+timeouts:
+  tcp: 15m
+`
+
+func TestNormalize(t *testing.T) {
+	c := integration.NewIPVSConfig()
+	assert.Nil(t, yaml.Unmarshal([]byte(normalizeModel), c))
+
+	n := c.Normalize()
+
+	b, err := yaml.Marshal(n)
+	assert.Nil(t, err)
+	assert.Equal(t, `defaults:
+  port: 8080
+  weight: 100
+  sched: wrr
+  forward: tunnel
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+  max-connections: 1000
+services:
+- address: tcp://10.0.0.1:8080
+  sched: wrr
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+  destinations:
+  - address: '[2001:db8::10]:8080'
+    weight: 100
+    forward: tunnel
+    max-connections: 1000
+    tunnel:
+      type: gue
+      port: 6080
+  - address: 10.1.0.2:80
+    weight: 50
+    forward: nat
+    max-connections: 1000
+    min-connections: 10
+- address: fwmark:42
+  sched: sh
+  persistent: 60s
+  persistence-netmask: 255.255.255.0
+  flags:
+  - sh-port
+- address: udp://10.0.0.1:53
+  sched: wrr
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+sync:
+- state: master
+  interface: eth0
+  group: 224.0.0.81
+  port: 8848
+  ttl: 1
+timeouts:
+  tcp: 15m
+`, string(b))
+
+	// the original model is left untouched
+	b, err = yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Equal(t, normalizeModel, string(b))
+
+	// normalizing is idempotent
+	b2, err := yaml.Marshal(n.Normalize())
+	assert.Nil(t, err)
+	b, _ = yaml.Marshal(n)
+	assert.Equal(t, string(b), string(b2))
+
+	// both describe the same configuration
+	assert.Nil(t, c.Validate())
+	assert.Nil(t, n.Validate())
+	for idx := range c.Services {
+		s1, err := c.NewIpvsServiceStruct(c.Services[idx])
+		assert.Nil(t, err)
+		s2, err := n.NewIpvsServiceStruct(n.Services[idx])
+		assert.Nil(t, err)
+		assert.Equal(t, s1, s2)
+
+		d1, err := c.NewIpvsDestinationsStruct(c.Services[idx])
+		assert.Nil(t, err)
+		d2, err := n.NewIpvsDestinationsStruct(n.Services[idx])
+		assert.Nil(t, err)
+		assert.Equal(t, d1, d2)
+	}
+	assert.True(t, integration.CompareSyncDaemonsEquality(c.Sync[0], n.Sync[0]))
+}
+
+func TestNormalizeBuiltinDefaults(t *testing.T) {
+	c := integration.NewIPVSConfig()
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+services:
+- address: tcp://10.0.0.1:80
+  destinations:
+  - address: 10.1.0.1:80
+`), c))
+
+	n := c.Normalize()
+	assert.Equal(t, "rr", n.Services[0].SchedName)
+	assert.Equal(t, "direct", n.Services[0].Destinations[0].Forward)
+	assert.Equal(t, 0, n.Services[0].Destinations[0].Weight)
+	assert.Equal(t, "", c.Services[0].SchedName)
+	assert.Equal(t, "", c.Services[0].Destinations[0].Forward)
+}
+
+func TestValidateDoesNotMutate(t *testing.T) {
+	c := integration.NewIPVSConfig()
+	assert.Nil(t, yaml.Unmarshal([]byte(normalizeModel), c))
+
+	// the mixed address families of services[0] require the default forward tunnel
+	assert.Nil(t, c.Validate())
+	assert.Len(t, c.ValidateAll(), 2)
+
+	b, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Equal(t, normalizeModel, string(b))
+}
@@ -11,12 +11,18 @@ func (ipvsconfig *IPVSConfig) ResolveParams(rc dynp.ResolverChain) (*IPVSConfig,
 	res := From(ipvsconfig)
 
 	res.Defaults = ipvsconfig.Defaults
+	res.Sync = ipvsconfig.Sync
+	res.Timeouts = ipvsconfig.Timeouts
 
 	res.Services = make([]*Service, len(ipvsconfig.Services))
 	for idx, service := range ipvsconfig.Services {
 		res.Services[idx] = &Service{
-			SchedName: service.SchedName,
-			service:   service.service,
+			SchedName:          service.SchedName,
+			Persistent:         service.Persistent,
+			PersistenceNetmask: service.PersistenceNetmask,
+			Flags:              service.Flags,
+			PEName:             service.PEName,
+			service:            service.service,
 		}
 
 		s, err := dynp.ResolveFromString(service.Address, rc)
@@ -28,9 +34,12 @@ func (ipvsconfig *IPVSConfig) ResolveParams(rc dynp.ResolverChain) (*IPVSConfig,
 		res.Services[idx].Destinations = make([]*Destination, len(service.Destinations))
 		for dIdx, destination := range service.Destinations {
 			res.Services[idx].Destinations[dIdx] = &Destination{
-				Weight:      destination.Weight,
-				Forward:     destination.Forward,
-				destination: destination.destination,
+				Weight:         destination.Weight,
+				Forward:        destination.Forward,
+				MaxConnections: destination.MaxConnections,
+				MinConnections: destination.MinConnections,
+				Tunnel:         destination.Tunnel,
+				destination:    destination.destination,
 			}
 
 			d, err := dynp.ResolveFromString(destination.Address, rc)
@@ -0,0 +1,293 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// IPVSDecodeError signals an error when strictly decoding a model
+type IPVSDecodeError struct {
+	line   int // line number of the offending node, 0 if unknown
+	column int // column number of the offending node, 0 if unknown
+	what   string
+}
+
+func (e *IPVSDecodeError) Error() string {
+	if e.line == 0 {
+		return fmt.Sprintf("Unable to decode model: %s", e.what)
+	}
+	if e.column == 0 {
+		return fmt.Sprintf("Unable to decode model, line %d: %s", e.line, e.what)
+	}
+	return fmt.Sprintf("Unable to decode model, line %d, column %d: %s", e.line, e.column, e.what)
+}
+
+// Line returns the line number of the offending node, 0 if unknown
+func (e *IPVSDecodeError) Line() int {
+	return e.line
+}
+
+// Column returns the column number of the offending node, 0 if unknown
+func (e *IPVSDecodeError) Column() int {
+	return e.column
+}
+
+// SchemaURI is the JSON Schema dialect of Schema
+const SchemaURI = "http://json-schema.org/draft-07/schema#"
+
+// schemaEnums contains the allowed values of string fields, by type and field name.
+// For lists, they apply to the items.
+var schemaEnums = map[string][]string{
+	"Defaults.sched":      schedNames,
+	"Defaults.forward":    forwardNames,
+	"Service.sched":       schedNames,
+	"Service.pe":          peNames,
+	"Service.flags":       serviceFlagNames(),
+	"Destination.forward": forwardNames,
+	"Tunnel.type":         {"ipip", "gue", "gre"},
+	"Tunnel.checksum":     {"nocsum", "csum", "remcsum"},
+	"SyncDaemon.state":    {"master", "backup"},
+}
+
+// serviceFlagNames returns all names of service flags serviceFlagsFromStrings accepts
+func serviceFlagNames() []string {
+	res := make([]string, 0, len(serviceFlags)+len(serviceFlagAliases))
+	for _, sf := range serviceFlags {
+		res = append(res, sf.name)
+	}
+	for alias := range serviceFlagAliases {
+		res = append(res, alias)
+	}
+	sort.Strings(res[len(serviceFlags):])
+	return res
+}
+
+// modelField is a field of a model struct, as seen by yaml and json
+type modelField struct {
+	name     string
+	required bool // no omitempty
+	typ      reflect.Type
+}
+
+// modelFields returns all serialized fields of a model struct type
+func modelFields(t reflect.Type) []modelField {
+	var res []modelField
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		mf := modelField{name: parts[0], required: true, typ: f.Type}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				mf.required = false
+			}
+		}
+		res = append(res, mf)
+	}
+	return res
+}
+
+// Schema returns a JSON Schema of the model, generated from the types
+// of IPVSConfig. It describes yaml and json models alike, dynamic parameters
+// are only allowed in addresses.
+func Schema() map[string]interface{} {
+	definitions := map[string]interface{}{}
+	res := schemaForStruct(reflect.TypeOf(IPVSConfig{}), definitions)
+	res["$schema"] = SchemaURI
+	res["title"] = "ipvsctl model"
+	res["definitions"] = definitions
+	return res
+}
+
+// schemaForType returns the schema of a field type. Structs are added to definitions
+// and referenced.
+func schemaForType(t reflect.Type, enum []string, definitions map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem(), enum, definitions)
+	case reflect.Struct:
+		if _, ex := definitions[t.Name()]; !ex {
+			definitions[t.Name()] = nil // guard against recursion
+			definitions[t.Name()] = schemaForStruct(t, definitions)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaForType(t.Elem(), enum, definitions),
+		}
+	case reflect.String:
+		res := map[string]interface{}{"type": "string"}
+		if enum != nil {
+			res["enum"] = enum
+		}
+		return res
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	}
+	return map[string]interface{}{}
+}
+
+func schemaForStruct(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, f := range modelFields(t) {
+		properties[f.name] = schemaForType(f.typ, schemaEnums[t.Name()+"."+f.name], definitions)
+		if f.required {
+			required = append(required, f.name)
+		}
+	}
+
+	res := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		res["required"] = required
+	}
+	return res
+}
+
+var yamlErrorLineRe = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// UnmarshalStrict decodes a yaml or json model into ipvsconfig. Other than
+// yaml.Unmarshal, it rejects unknown fields and values of the wrong type,
+// returning an IPVSDecodeError with the line and column of the offending node.
+// Valid json documents are decoded as json.
+func (ipvsconfig *IPVSConfig) UnmarshalStrict(b []byte) error {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		m := yamlErrorLineRe.FindStringSubmatch(err.Error())
+		if m == nil {
+			return &IPVSDecodeError{what: err.Error()}
+		}
+		line, _ := strconv.Atoi(m[1])
+		return &IPVSDecodeError{line: line, what: m[2]}
+	}
+
+	if len(doc.Content) > 0 {
+		if err := checkModelNode(doc.Content[0], reflect.TypeOf(IPVSConfig{}), ""); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if json.Valid(b) {
+		err = json.Unmarshal(b, ipvsconfig)
+	} else {
+		err = yaml.Unmarshal(b, ipvsconfig)
+	}
+	if err != nil {
+		return &IPVSDecodeError{what: err.Error()}
+	}
+	return nil
+}
+
+// checkModelNode checks that a node and its children match the type of a
+// model field. path describes the position within the model, e.g. services[0].
+func checkModelNode(n *yamlv3.Node, t reflect.Type, path string) error {
+	if n.Kind == yamlv3.AliasNode && n.Alias != nil {
+		return checkModelNode(n.Alias, t, path)
+	}
+	if n.Kind == yamlv3.ScalarNode && n.ShortTag() == "!!null" {
+		return nil
+	}
+
+	where := path
+	if where == "" {
+		where = "model"
+	}
+	mismatch := func(expected string) error {
+		got := n.ShortTag()
+		if n.Kind == yamlv3.ScalarNode {
+			got = fmt.Sprintf("%s %q", got, n.Value)
+		}
+		return &IPVSDecodeError{
+			line:   n.Line,
+			column: n.Column,
+			what:   fmt.Sprintf("%s: expected %s, got %s", where, expected, got),
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkModelNode(n, t.Elem(), path)
+	case reflect.Struct:
+		if n.Kind != yamlv3.MappingNode {
+			return mismatch("object")
+		}
+		fields := map[string]reflect.Type{}
+		for _, f := range modelFields(t) {
+			fields[f.name] = f.typ
+		}
+		seen := map[string]bool{}
+		for idx := 0; idx+1 < len(n.Content); idx += 2 {
+			k, v := n.Content[idx], n.Content[idx+1]
+			ft, ex := fields[k.Value]
+			if !ex {
+				return &IPVSDecodeError{
+					line:   k.Line,
+					column: k.Column,
+					what:   fmt.Sprintf("unknown field %q in %s", k.Value, where),
+				}
+			}
+			if seen[k.Value] {
+				return &IPVSDecodeError{
+					line:   k.Line,
+					column: k.Column,
+					what:   fmt.Sprintf("duplicate field %q in %s", k.Value, where),
+				}
+			}
+			seen[k.Value] = true
+
+			fieldPath := k.Value
+			if path != "" {
+				fieldPath = path + "." + k.Value
+			}
+			if err := checkModelNode(v, ft, fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if n.Kind != yamlv3.SequenceNode {
+			return mismatch("list")
+		}
+		for idx, item := range n.Content {
+			if err := checkModelNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if n.Kind != yamlv3.ScalarNode {
+			return mismatch("string")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n.Kind != yamlv3.ScalarNode || n.ShortTag() != "!!int" {
+			return mismatch("integer")
+		}
+	case reflect.Bool:
+		if n.Kind != yamlv3.ScalarNode || n.ShortTag() != "!!bool" {
+			return mismatch("boolean")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const strictModel = `
+defaults:
+  port: 8080
+  weight: 100
+services:
+- address: tcp://10.0.0.1:80
+  sched: sh
+  flags: [ sh-port ]
+  destinations:
+  - address: 10.1.0.1
+    forward: tunnel
+    tunnel:
+      type: gue
+      port: 6080
+  - address: ${dest}
+    max-connections: 1000
+sync:
+- state: master
+  interface: eth0
+timeouts:
+  tcp: 15m
+`
+
+func TestUnmarshalStrict(t *testing.T) {
+	expected := integration.NewIPVSConfig()
+	assert.Nil(t, yaml.Unmarshal([]byte(strictModel), expected))
+
+	c := integration.NewIPVSConfig()
+	assert.Nil(t, c.UnmarshalStrict([]byte(strictModel)))
+	assert.Equal(t, expected.Services, c.Services)
+	assert.Equal(t, expected.Defaults, c.Defaults)
+	assert.Equal(t, expected.Sync, c.Sync)
+	assert.Equal(t, expected.Timeouts, c.Timeouts)
+
+	// json
+	b, err := json.Marshal(expected)
+	assert.Nil(t, err)
+	c = integration.NewIPVSConfig()
+	assert.Nil(t, c.UnmarshalStrict(b))
+	assert.Equal(t, expected.Services, c.Services)
+
+	// empty and null values
+	c = integration.NewIPVSConfig()
+	assert.Nil(t, c.UnmarshalStrict([]byte("")))
+	assert.Nil(t, c.UnmarshalStrict([]byte("defaults:\nservices: ~\n")))
+}
+
+func TestUnmarshalStrictErrors(t *testing.T) {
+	var tests = []struct {
+		model  string
+		line   int
+		column int
+	}{
+		{"services:\n- address: tcp://10.0.0.1:80\n  destinations:\n  - address: 10.1.0.1\n    wieght: 1\n", 5, 5},
+		{"service:\n- address: tcp://10.0.0.1:80\n", 1, 1},
+		{"services:\n- address: tcp://10.0.0.1:80\n  destinations:\n  - address: 10.1.0.1\n    weight: heavy\n", 5, 13},
+		{"services:\n  address: tcp://10.0.0.1:80\n", 2, 3},
+		{"services:\n- address: tcp://10.0.0.1:80\n  flags: sh-port\n", 3, 10},
+		{"defaults:\n  port: \"80\"\n", 2, 9},
+		{"services:\n- address: tcp://10.0.0.1:80\n  sched: rr\n  sched: wrr\n", 4, 3},
+		{"timeouts:\n  tcp: 15m\n  sctp: 1m\n", 3, 3},
+		{"{\n  \"services\": [\n    { \"adress\": \"tcp://10.0.0.1:80\" }\n  ]\n}", 3, 7},
+		{"services:\n- address: [\n", 2, 0},
+		// malformed input, panicked in yaml.v3 before v3.0.1 (CVE-2022-28948)
+		{"0: [:!00 \xef", 0, 0},
+	}
+
+	for _, test := range tests {
+		c := integration.NewIPVSConfig()
+		err := c.UnmarshalStrict([]byte(test.model))
+		decodeErr, ok := err.(*integration.IPVSDecodeError)
+		if assert.True(t, ok, "expected decode error for %q, got %v", test.model, err) {
+			assert.Equal(t, test.line, decodeErr.Line(), test.model)
+			assert.Equal(t, test.column, decodeErr.Column(), test.model)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s := integration.Schema()
+	assert.Equal(t, integration.SchemaURI, s["$schema"])
+	assert.Equal(t, false, s["additionalProperties"])
+
+	// must be serializable
+	_, err := json.Marshal(s)
+	assert.Nil(t, err)
+
+	definitions := s["definitions"].(map[string]interface{})
+	for _, name := range []string{"Defaults", "Service", "Destination", "Tunnel", "SyncDaemon", "Timeouts"} {
+		assert.Contains(t, definitions, name)
+	}
+
+	destination := definitions["Destination"].(map[string]interface{})
+	assert.Equal(t, []string{"address"}, destination["required"])
+	properties := destination["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "integer"}, properties["weight"])
+	assert.Equal(t, map[string]interface{}{"$ref": "#/definitions/Tunnel"}, properties["tunnel"])
+
+	// all flag names the parser accepts, including aliases
+	service := definitions["Service"].(map[string]interface{})
+	flags := service["properties"].(map[string]interface{})["flags"].(map[string]interface{})
+	enum := flags["items"].(map[string]interface{})["enum"].([]string)
+	assert.Contains(t, enum, "ops")
+	assert.Contains(t, enum, "one-packet")
+	assert.Contains(t, enum, "sh-port")
+
+	services := s["properties"].(map[string]interface{})["services"].(map[string]interface{})
+	assert.Equal(t, "array", services["type"])
+	assert.Equal(t, map[string]interface{}{"$ref": "#/definitions/Service"}, services["items"])
+}
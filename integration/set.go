@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -18,8 +19,18 @@ func (e *IPVSetError) Error() string {
 	return fmt.Sprintf("Unable to set new value: %s\nReason: %s", e.what, e.origErr)
 }
 
+// Unwrap returns the underlying error, e.g. an ipvs.TimeoutError
+func (e *IPVSetError) Unwrap() error {
+	return e.origErr
+}
+
 // SetWeight sets the destination's weight to newWeight
 func (ipvsconfig *IPVSConfig) SetWeight(serviceName, destinationName string, newWeight int) error {
+	return ipvsconfig.SetWeightContext(context.Background(), serviceName, destinationName, newWeight)
+}
+
+// SetWeightContext is like SetWeight, but honours the deadline and cancellation of ctx.
+func (ipvsconfig *IPVSConfig) SetWeightContext(ctx context.Context, serviceName, destinationName string, newWeight int) error {
 	s, d := ipvsconfig.LocateServiceAndDestination(serviceName, destinationName)
 	if s == nil {
 		return &IPVSetError{what: fmt.Sprintf("Service %s not found in active ipvs configuration. Try ipvsctl get\n", serviceName)}
@@ -41,7 +52,7 @@ func (ipvsconfig *IPVSConfig) SetWeight(serviceName, destinationName string, new
 
 	ipvsconfig.log.Printf("applying changeset %s\n", cs)
 
-	err := ipvsconfig.ApplyChangeSet(ipvsconfig, cs, ApplyOpts{
+	err := ipvsconfig.ApplyChangeSetContext(ctx, ipvsconfig, cs, ApplyOpts{
 		AllowedActions: ApplyActions{
 			ApplyActionUpdateDestination: true,
 		}})
@@ -51,6 +62,41 @@ func (ipvsconfig *IPVSConfig) SetWeight(serviceName, destinationName string, new
 	return err
 }
 
+// SetTimeouts sets the global connection timeouts. Omitted timeouts are left unchanged.
+func (ipvsconfig *IPVSConfig) SetTimeouts(t *Timeouts) error {
+	return ipvsconfig.SetTimeoutsContext(context.Background(), t)
+}
+
+// SetTimeoutsContext is like SetTimeouts, but honours the deadline and cancellation of ctx.
+func (ipvsconfig *IPVSConfig) SetTimeoutsContext(ctx context.Context, t *Timeouts) error {
+	if t == nil || (t.TCP == "" && t.TCPFin == "" && t.UDP == "") {
+		return &IPVSetError{what: "no timeouts given"}
+	}
+
+	err := (&IPVSConfig{Timeouts: t}).Validate()
+	if err != nil {
+		return &IPVSetError{what: "invalid timeouts", origErr: err}
+	}
+
+	// create changeset
+	cs := NewChangeSet()
+	cs.AddChange(ChangeSetItem{
+		Type:     UpdateTimeouts,
+		Timeouts: t,
+	})
+
+	ipvsconfig.log.Printf("applying changeset %s\n", cs)
+
+	err = ipvsconfig.ApplyChangeSetContext(ctx, ipvsconfig, cs, ApplyOpts{
+		AllowedActions: ApplyActions{
+			ApplyActionUpdateTimeouts: true,
+		}})
+	if err == nil {
+		ipvsconfig.log.Printf("Updated timeouts to %#v\n", t)
+	}
+	return err
+}
+
 const (
 	// ControlAdvance advances the time ticker (see cmd/set.go)
 	ControlAdvance = 1
@@ -70,9 +116,21 @@ func (ipvsconfig *IPVSConfig) SetWeightContinuous(
 	toWeight int,
 	amountOfTimeSecs int,
 	cch ContinousControlCh) error {
+	return ipvsconfig.SetWeightContinuousContext(context.Background(), serviceName, destinationName, toWeight, amountOfTimeSecs, cch)
+}
+
+// SetWeightContinuousContext is like SetWeightContinuous, but honours the deadline
+// and cancellation of ctx. ctx covers all updates, so its deadline must allow
+// for amountOfTimeSecs.
+func (ipvsconfig *IPVSConfig) SetWeightContinuousContext(
+	ctx context.Context,
+	serviceName, destinationName string,
+	toWeight int,
+	amountOfTimeSecs int,
+	cch ContinousControlCh) error {
 
 	if amountOfTimeSecs <= 1 {
-		return ipvsconfig.SetWeight(serviceName, destinationName, toWeight)
+		return ipvsconfig.SetWeightContext(ctx, serviceName, destinationName, toWeight)
 	}
 
 	s, d := ipvsconfig.LocateServiceAndDestination(serviceName, destinationName)
@@ -103,7 +161,7 @@ func (ipvsconfig *IPVSConfig) SetWeightContinuous(
 			return nil
 
 		case ControlFinish:
-			return ipvsconfig.SetWeight(serviceName, destinationName, toWeight)
+			return ipvsconfig.SetWeightContext(ctx, serviceName, destinationName, toWeight)
 
 		case ControlAdvance:
 			timeElapsed := time.Now().Sub(timeStart)
@@ -114,7 +172,7 @@ func (ipvsconfig *IPVSConfig) SetWeightContinuous(
 				}
 				d.Weight = int(float64(fromWeight) + float64(toWeight-fromWeight)*percElapsed)
 
-				err := ipvsconfig.ApplyChangeSet(ipvsconfig, cs, ApplyOpts{
+				err := ipvsconfig.ApplyChangeSetContext(ctx, ipvsconfig, cs, ApplyOpts{
 					AllowedActions: ApplyActions{
 						ApplyActionUpdateDestination: true,
 					}})
@@ -3,24 +3,102 @@ package integration
 import (
 	"fmt"
 	"net"
+	"syscall"
+
+	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
 )
 
 // IPVSValidateError signal an error when validating a configuration
 type IPVSValidateError struct {
-	What string
+	What   string            // message of the first error
+	Issues []ValidationIssue // all errors and warnings
 }
 
 func (e *IPVSValidateError) Error() string {
+	n := 0
+	for _, issue := range e.Issues {
+		if issue.Severity == SeverityError {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Sprintf("Configuration not valid: %s (and %d more errors)", e.What, n-1)
+	}
 	return fmt.Sprintf("Configuration not valid: %s", e.What)
 }
 
+// ValidationSeverity is the severity of a validation issue
+type ValidationSeverity string
+
+const (
+	// SeverityError marks issues which prevent a model from being applied
+	SeverityError ValidationSeverity = "error"
+	// SeverityWarning marks issues which are valid but probably not intended
+	SeverityWarning ValidationSeverity = "warning"
+)
+
+// ValidationIssue is a single problem found when validating a model
+type ValidationIssue struct {
+	Path     string             `yaml:"path" json:"path"` // position in the model, e.g. services[3].destinations[1].weight, empty for the model itself
+	Severity ValidationSeverity `yaml:"severity" json:"severity"`
+	Code     string             `yaml:"code" json:"code"` // e.g. invalid-weight
+	Message  string             `yaml:"message" json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	if i.Path == "" {
+		return fmt.Sprintf("%s: %s (%s)", i.Severity, i.Message, i.Code)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", i.Severity, i.Path, i.Message, i.Code)
+}
+
+// validation collects the issues of a single validation run
+type validation struct {
+	issues []ValidationIssue
+}
+
+func (v *validation) errorf(path, code, format string, a ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{Path: path, Severity: SeverityError, Code: code, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validation) warnf(path, code, format string, a ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{Path: path, Severity: SeverityWarning, Code: code, Message: fmt.Sprintf(format, a...)})
+}
+
+// err returns an IPVSValidateError with all issues if there is at least one error
+func (v *validation) err() error {
+	for _, issue := range v.issues {
+		if issue.Severity == SeverityError {
+			return &IPVSValidateError{What: issue.Message, Issues: v.issues}
+		}
+	}
+	return nil
+}
+
 var (
-	schedNames   = []string{"rr", "wrr", "lc", "wlc", "lblc", "lblcr", "dh", "sh", "sed", "nq"}
+	schedNames   = []string{"rr", "wrr", "lc", "wlc", "lblc", "lblcr", "dh", "sh", "sed", "nq", "mh", "fo", "ovf", "twos"}
 	forwardNames = []string{"direct", "nat", "tunnel"}
+	peNames      = []string{"sip"}
 )
 
-// Validate checks ipvsconfig for structural errors
+// Validate checks ipvsconfig for structural errors. It does not modify ipvsconfig,
+// defaults are taken into account but not written to the model. It returns an IPVSValidateError
+// carrying all issues if there is at least one error, warnings alone pass.
 func (ipvsconfig *IPVSConfig) Validate() error {
+	v := &validation{}
+	ipvsconfig.validate(v)
+	return v.err()
+}
+
+// ValidateAll checks ipvsconfig for structural errors and returns all errors
+// and warnings found, in the order of the model
+func (ipvsconfig *IPVSConfig) ValidateAll() []ValidationIssue {
+	v := &validation{}
+	ipvsconfig.validate(v)
+	return v.issues
+}
+
+func (ipvsconfig *IPVSConfig) validate(v *validation) {
 
 	defaultPort := 0
 	defaultWeight := 0
@@ -28,163 +106,348 @@ func (ipvsconfig *IPVSConfig) Validate() error {
 	defaultForward := ""
 
 	if ipvsconfig.Defaults.Port != nil {
-		v := *ipvsconfig.Defaults.Port
-		if v < 1 || v > 65535 {
-			return &IPVSValidateError{What: fmt.Sprintf("Default port out of range: %d", v)}
+		p := *ipvsconfig.Defaults.Port
+		if p < 1 || p > 65535 {
+			v.errorf("defaults.port", "invalid-port", "Default port out of range: %d", p)
+		} else {
+			defaultPort = p
 		}
-		defaultPort = v
 	}
 	if ipvsconfig.Defaults.Weight != nil {
-		v := *ipvsconfig.Defaults.Weight
-		if v < 0 || v > 65535 {
-			return &IPVSValidateError{What: fmt.Sprintf("Default weight out of range: %d", v)}
+		w := *ipvsconfig.Defaults.Weight
+		if w < 0 || w > 65535 {
+			v.errorf("defaults.weight", "invalid-weight", "Default weight out of range: %d", w)
+		} else {
+			defaultWeight = w
 		}
-		defaultWeight = v
 	}
 	if ipvsconfig.Defaults.SchedName != nil {
-		bOk := false
-		for _, sn := range schedNames {
-			if sn == *ipvsconfig.Defaults.SchedName {
-				bOk = true
-			}
-		}
-		if !bOk {
-			return &IPVSValidateError{What: fmt.Sprintf("invalid default scheduler: %s", *ipvsconfig.Defaults.SchedName)}
+		if !contains(schedNames, *ipvsconfig.Defaults.SchedName) {
+			v.errorf("defaults.sched", "invalid-scheduler", "invalid default scheduler: %s", *ipvsconfig.Defaults.SchedName)
+		} else {
+			defaultSched = *ipvsconfig.Defaults.SchedName
 		}
-		defaultSched = *ipvsconfig.Defaults.SchedName
 	}
 	if ipvsconfig.Defaults.Forward != nil {
-		bOk := false
-		for _, sn := range forwardNames {
-			if sn == *ipvsconfig.Defaults.Forward {
-				bOk = true
-			}
+		if !contains(forwardNames, *ipvsconfig.Defaults.Forward) {
+			v.errorf("defaults.forward", "invalid-forward", "invalid default forward: %s. Allowed forwards are direct,nat,tunnel", *ipvsconfig.Defaults.Forward)
+		} else {
+			defaultForward = *ipvsconfig.Defaults.Forward
+		}
+	}
+
+	if ipvsconfig.Defaults.MaxConnections != nil {
+		if c := *ipvsconfig.Defaults.MaxConnections; c < 0 {
+			v.errorf("defaults.max-connections", "invalid-max-connections", "Default max-connections out of range: %d", c)
+		}
+	}
+	if ipvsconfig.Defaults.MinConnections != nil {
+		if c := *ipvsconfig.Defaults.MinConnections; c < 0 {
+			v.errorf("defaults.min-connections", "invalid-min-connections", "Default min-connections out of range: %d", c)
 		}
-		if !bOk {
-			return &IPVSValidateError{What: fmt.Sprintf("invalid default forward: %s. Allowed forwards are direct,nat,tunnel", *ipvsconfig.Defaults.Forward)}
+	}
+	if ipvsconfig.Defaults.Persistent != nil {
+		if secs, err := parseSeconds(*ipvsconfig.Defaults.Persistent); err != nil {
+			v.errorf("defaults.persistent", "invalid-persistent", "invalid default persistent: %s", err)
+		} else if secs == 0 {
+			v.errorf("defaults.persistent", "invalid-persistent", "invalid default persistent (%s). Must be a duration of at least 1s.", *ipvsconfig.Defaults.Persistent)
+		}
+	}
+	if ipvsconfig.Defaults.PersistenceNetmask != nil {
+		// must be valid at least for one of the address families
+		nm := *ipvsconfig.Defaults.PersistenceNetmask
+		_, err4 := parsePersistenceNetmask(nm, syscall.AF_INET)
+		_, err6 := parsePersistenceNetmask(nm, syscall.AF_INET6)
+		if err4 != nil && err6 != nil {
+			v.errorf("defaults.persistence-netmask", "invalid-persistence-netmask", "invalid default persistence-netmask: %s", nm)
 		}
-		defaultForward = *ipvsconfig.Defaults.Forward
 	}
 
 	serviceMap := make(map[string]bool)
 
-	for _, service := range ipvsconfig.Services {
+	for sIdx, service := range ipvsconfig.Services {
+		path := fmt.Sprintf("services[%d]", sIdx)
+
+		// addressOk signals that proto, fwmark and the address family of the service are known
+		addressOk := false
+		proto, fwmark := "", 0
+
 		if service.Address == "" {
-			return &IPVSValidateError{What: fmt.Sprintf("Service address may not be empty")}
-		}
+			v.errorf(path+".address", "empty-address", "Service address may not be empty")
+		} else if serviceMap[service.Address] {
+			v.errorf(path+".address", "duplicate-address", "Service addresses must be unique: %s", service.Address)
+		} else {
+			serviceMap[service.Address] = true
 
-		//
-		_, ex := serviceMap[service.Address]
-		if ex {
-			return &IPVSValidateError{What: fmt.Sprintf("Service addresses must be unique: %s", service.Address)}
+			//proto, adrpart, port, fwmark, err
+			var adrpart string
+			var err error
+			proto, adrpart, _, fwmark, err = splitCompoundAddress(service.Address)
+			if err != nil {
+				v.errorf(path+".address", "invalid-address", "unable to parse address (%s). Must be of format <proto>://<host>[:port] or fwmark:<id>.", service.Address)
+			} else if fwmark == 0 {
+				// check for ip address
+				if net.ParseIP(adrpart) == nil {
+					v.errorf(path+".address", "invalid-address", "unable to parse address (%s). Not an IP address.", adrpart)
+				} else {
+					addressOk = true
+				}
+			} else if fwmark < 0 || fwmark > 65535 {
+				v.errorf(path+".address", "invalid-fwmark", "unable to parse address (%s). Invalid fwmark number.", adrpart)
+			} else {
+				addressOk = true
+			}
 		}
-		serviceMap[service.Address] = true
 
-		//proto, adrpart, port, fwmark, err
-		_, adrpart, _, fwmark, err := splitCompoundAddress(service.Address)
-		if err != nil {
-			es := fmt.Sprintf("unable to parse address (%s). Must be of format <proto>://<host>[:port] or fwmark:<id>.", service.Address)
-			return &IPVSValidateError{What: es}
+		// check scheduler if given. Defaults are applied to local copies only,
+		// the model is left untouched (see Normalize).
+		sched := service.SchedName
+		if sched == "" {
+			sched = defaultSched
+		}
+		schedOk := true
+		if sched != "" && !contains(schedNames, sched) {
+			v.errorf(path+".sched", "invalid-scheduler", "invalid scheduler (%s) for service (%s).", sched, service.Address)
+			schedOk = false
+		}
+		if sched == "" {
+			sched = "rr"
 		}
 
-		if fwmark == 0 {
-			// check for ip address
-			ip := net.ParseIP(adrpart)
-			if ip == nil {
-				return &IPVSValidateError{What: fmt.Sprintf("unable to parse address (%s). Not an IP address.", adrpart)}
+		// check flags against scheduler and protocol
+		if len(service.Flags) > 0 && schedOk {
+			flags, err := serviceFlagsFromStrings(service.Flags, sched)
+			if err != nil {
+				v.errorf(path+".flags", "invalid-flags", "invalid flags for service (%s): %s", service.Address, err)
+			} else if addressOk && flags&ipvs.SvcFlagOnePacket != 0 && proto != "udp" && fwmark == 0 {
+				v.errorf(path+".flags", "ops-requires-udp", "flag ops is only valid for udp services (%s).", service.Address)
 			}
+		}
 
-			if !(len(ip) == net.IPv6len &&
-				ip[0] == 0x0 &&
-				ip[1] == 0x0 &&
-				ip[2] == 0x0 &&
-				ip[3] == 0x0 &&
-				ip[4] == 0x0 &&
-				ip[5] == 0x0 &&
-				ip[6] == 0x0 &&
-				ip[7] == 0x0 &&
-				ip[8] == 0x0 &&
-				ip[9] == 0x0 &&
-				ip[10] == 0xff &&
-				ip[11] == 0xff) {
-				return &IPVSValidateError{What: fmt.Sprintf("unable to parse address (%s). IPv6 not supported.", adrpart)}
+		// check persistence
+		persistentOk := true
+		if service.Persistent != "" {
+			if secs, err := parseSeconds(service.Persistent); err != nil {
+				v.errorf(path+".persistent", "invalid-persistent", "invalid persistent (%s) for service (%s): %s", service.Persistent, service.Address, err)
+				persistentOk = false
+			} else if secs == 0 {
+				v.errorf(path+".persistent", "invalid-persistent", "invalid persistent (%s) for service (%s). Must be a duration of at least 1s.", service.Persistent, service.Address)
+				persistentOk = false
 			}
-		} else {
-			if fwmark < 0 || fwmark > 65535 {
-				return &IPVSValidateError{What: fmt.Sprintf("unable to parse address (%s). Invalid fwmark number.", adrpart)}
+		}
+		if persistentOk {
+			timeout, _, err := ipvsconfig.servicePersistence(service)
+			if err != nil {
+				v.errorf(path+".persistence-netmask", "invalid-persistence", "invalid persistence for service (%s): %s", service.Address, err)
+			} else {
+				if service.PersistenceNetmask != "" && timeout == 0 {
+					v.errorf(path+".persistence-netmask", "netmask-requires-persistent", "persistence-netmask requires persistent for service (%s).", service.Address)
+				}
+				if service.PEName != "" && contains(peNames, service.PEName) && timeout == 0 {
+					v.errorf(path+".pe", "pe-requires-persistent", "persistence engine requires persistent for service (%s).", service.Address)
+				}
 			}
 		}
 
-		// check scheduler if given
-		if service.SchedName == "" && defaultSched != "" {
-			service.SchedName = defaultSched
+		// check persistence engine if given
+		if service.PEName != "" && !contains(peNames, service.PEName) {
+			v.errorf(path+".pe", "invalid-pe", "invalid persistence engine (%s) for service (%s). Allowed are sip", service.PEName, service.Address)
 		}
-		if service.SchedName != "" {
-			bOk := false
-			for _, sn := range schedNames {
-				if sn == service.SchedName {
-					bOk = true
-					break
-				}
-			}
-			if !bOk {
-				return &IPVSValidateError{What: fmt.Sprintf("invalid scheduler (%s) for service (%s).", service.SchedName, service.Address)}
-			}
+
+		if len(service.Destinations) == 0 {
+			v.warnf(path+".destinations", "no-destinations", "service (%s) has no destinations.", service.Address)
 		}
 
 		// check destination addresses
 		destinationMap := make(map[string]bool)
 
-		for _, destination := range service.Destinations {
+		for dIdx, destination := range service.Destinations {
+			dPath := fmt.Sprintf("%s.destinations[%d]", path, dIdx)
+
+			var ip net.IP
 			if destination.Address == "" {
-				return &IPVSValidateError{What: fmt.Sprintf("Destination address may not be empty for service %s", service.Address)}
-			}
+				v.errorf(dPath+".address", "empty-address", "Destination address may not be empty for service %s", service.Address)
+			} else if destinationMap[destination.Address] {
+				v.errorf(dPath+".address", "duplicate-address", "Destination addresses must be unique per service: %s in service %s", destination.Address, service.Address)
+			} else {
+				destinationMap[destination.Address] = true
 
-			_, ex := destinationMap[destination.Address]
-			if ex {
-				return &IPVSValidateError{What: fmt.Sprintf("Destination addresses must be unique per service: %s in service %s", destination.Address, service.Address)}
+				h, p, err := splitHostPort(destination.Address)
+				if err != nil {
+					v.errorf(dPath+".address", "invalid-address", "unable to parse address (%s) for service %s. Check host and port.", destination.Address, service.Address)
+				} else if ip = net.ParseIP(h); ip == nil {
+					// check for ip address
+					v.errorf(dPath+".address", "invalid-address", "unable to parse address (%s) for service %s. Not an IP address.", h, service.Address)
+				} else {
+					if p == 0 {
+						p = defaultPort
+					}
+					if p < 1 || p > 65535 {
+						v.errorf(dPath+".address", "invalid-port", "invalid port (%d) for destination %s in service %s.", p, destination.Address, service.Address)
+					}
+				}
 			}
-			destinationMap[destination.Address] = true
 
-			h, p, err := splitHostPort(destination.Address)
-			if err != nil {
-				return &IPVSValidateError{What: fmt.Sprintf("unable to parse address (%s) for service %s. Check host and port.", destination.Address, service.Address)}
+			forward := destination.Forward
+			if forward == "" {
+				forward = defaultForward
 			}
-			// check for ip address
-			ip := net.ParseIP(h)
-			if ip == nil {
-				return &IPVSValidateError{What: fmt.Sprintf("unable to parse address (%s) for service %s. Not an IP address.", h, service.Address)}
+			if forward != "" && !contains(forwardNames, forward) {
+				v.errorf(dPath+".forward", "invalid-forward", "invalid forward (%s) for destination %s in service %s. Allowed are direct,nat,tunnel", forward, destination.Address, service.Address)
 			}
-			if p == 0 {
-				p = defaultPort
+
+			weight := destination.Weight
+			if weight == 0 {
+				weight = defaultWeight
 			}
-			if p < 1 || p > 65535 {
-				return &IPVSValidateError{What: fmt.Sprintf("invalid port (%d) for destination %s in service %s.", p, destination.Address, service.Address)}
+			if weight < 0 || weight > 65535 {
+				v.errorf(dPath+".weight", "invalid-weight", "invalid weight (%d) for destination %s in service %s.", weight, destination.Address, service.Address)
+			} else if weight == 0 {
+				v.warnf(dPath+".weight", "zero-weight", "weight of destination %s in service %s is 0, it does not receive new connections.", destination.Address, service.Address)
 			}
-			if destination.Forward == "" && defaultForward != "" {
-				destination.Forward = defaultForward
+
+			// the kernel allows mixed address families only for tunnel forwarding
+			if ip != nil && addressOk && addressFamilyOf(ip) != serviceAddressFamily(service) && forward != "tunnel" {
+				v.errorf(dPath+".address", "mixed-address-family", "address family of destination %s does not match service %s. Mixed address families require forward tunnel.", destination.Address, service.Address)
 			}
-			if destination.Forward != "" {
-				bOk := false
-				for _, sn := range forwardNames {
-					if sn == destination.Forward {
-						bOk = true
-					}
+
+			if destination.Tunnel != nil {
+				tPath := dPath + ".tunnel"
+				if forward != "tunnel" {
+					v.errorf(tPath, "tunnel-requires-forward-tunnel", "tunnel requires forward tunnel for destination %s in service %s.", destination.Address, service.Address)
 				}
-				if !bOk {
-					return &IPVSValidateError{What: fmt.Sprintf("invalid forward (%s) for destination %s in service %s. Allowed are direct,nat,tunnel", destination.Forward, destination.Address, service.Address)}
+				if _, _, _, err := tunnelToIpvs(destination.Tunnel); err != nil {
+					v.errorf(tPath, "invalid-tunnel", "invalid tunnel for destination %s in service %s: %s", destination.Address, service.Address, err)
+				} else {
+					isGUE := destination.Tunnel.Type == "gue"
+					if isGUE && destination.Tunnel.Port == 0 {
+						v.errorf(tPath+".port", "tunnel-port-required", "tunnel type gue requires a port for destination %s in service %s.", destination.Address, service.Address)
+					}
+					if !isGUE && destination.Tunnel.Port != 0 {
+						v.errorf(tPath+".port", "tunnel-port-not-allowed", "tunnel port is only valid for type gue for destination %s in service %s.", destination.Address, service.Address)
+					}
+					isIPIP := destination.Tunnel.Type == "" || destination.Tunnel.Type == "ipip"
+					if isIPIP && destination.Tunnel.Checksum != "" && destination.Tunnel.Checksum != "nocsum" {
+						v.errorf(tPath+".checksum", "tunnel-checksum-not-allowed", "tunnel checksum is only valid for types gue and gre for destination %s in service %s.", destination.Address, service.Address)
+					} else if !isGUE && destination.Tunnel.Checksum == "remcsum" {
+						v.errorf(tPath+".checksum", "tunnel-checksum-not-allowed", "tunnel checksum remcsum is only valid for type gue for destination %s in service %s.", destination.Address, service.Address)
+					}
 				}
 			}
 
-			if destination.Weight == 0 && defaultWeight != 0 {
-				destination.Weight = defaultWeight
+			upper, lower := ipvsconfig.destinationThresholds(destination)
+			if upper < 0 || int64(upper) > int64(^uint32(0)) {
+				v.errorf(dPath+".max-connections", "invalid-max-connections", "invalid max-connections (%d) for destination %s in service %s.", upper, destination.Address, service.Address)
+			} else if lower < 0 || lower > upper {
+				v.errorf(dPath+".min-connections", "invalid-min-connections", "invalid min-connections (%d) for destination %s in service %s. Must not exceed max-connections.", lower, destination.Address, service.Address)
+			}
+		}
+	}
+
+	syncStateMap := make(map[string]bool)
+
+	for idx, sd := range ipvsconfig.Sync {
+		path := fmt.Sprintf("sync[%d]", idx)
+
+		if _, err := syncStateFromString(sd.State); err != nil {
+			v.errorf(path+".state", "invalid-state", "invalid sync daemon state (%s). Allowed are master,backup", sd.State)
+		} else if syncStateMap[sd.State] {
+			v.errorf(path+".state", "duplicate-state", "Sync daemon states must be unique: %s", sd.State)
+		}
+		syncStateMap[sd.State] = true
+
+		if sd.Interface == "" {
+			v.errorf(path+".interface", "empty-interface", "Interface may not be empty for %s sync daemon", sd.State)
+		}
+		if sd.SyncID < 0 || sd.SyncID > 255 {
+			v.errorf(path+".sync-id", "invalid-sync-id", "invalid sync-id (%d) for %s sync daemon.", sd.SyncID, sd.State)
+		}
+		if sd.Group != "" {
+			ip := net.ParseIP(sd.Group)
+			if ip == nil || !ip.IsMulticast() {
+				v.errorf(path+".group", "invalid-group", "invalid group (%s) for %s sync daemon. Not a multicast address.", sd.Group, sd.State)
+			}
+		}
+		if sd.Port < 0 || sd.Port > 65535 {
+			v.errorf(path+".port", "invalid-port", "invalid port (%d) for %s sync daemon.", sd.Port, sd.State)
+		}
+		if sd.TTL < 0 || sd.TTL > 255 {
+			v.errorf(path+".ttl", "invalid-ttl", "invalid ttl (%d) for %s sync daemon.", sd.TTL, sd.State)
+		}
+	}
+
+	if ipvsconfig.Timeouts != nil {
+		for _, x := range []struct{ name, value string }{
+			{"tcp", ipvsconfig.Timeouts.TCP},
+			{"tcpfin", ipvsconfig.Timeouts.TCPFin},
+			{"udp", ipvsconfig.Timeouts.UDP},
+		} {
+			if x.value == "" {
+				continue
 			}
-			if destination.Weight < 0 || destination.Weight > 65535 {
-				return &IPVSValidateError{What: fmt.Sprintf("invalid weight (%d) for destination %s in service %s.", destination.Weight, destination.Address, service.Address)}
+			secs, err := parseSeconds(x.value)
+			if err != nil || secs == 0 {
+				v.errorf("timeouts."+x.name, "invalid-timeout", "invalid %s timeout (%s). Must be a duration of at least 1s.", x.name, x.value)
 			}
+		}
+	}
+
+	if ipvsconfig.capabilities != nil {
+		ipvsconfig.validateCapabilities(v, ipvsconfig.capabilities)
+	}
+}
+
+// validateCapabilities rejects models which the kernel described by caps cannot run
+func (ipvsconfig *IPVSConfig) validateCapabilities(v *validation, caps *ipvs.Capabilities) {
+	if !caps.FamilyPresent && (len(ipvsconfig.Services) > 0 || len(ipvsconfig.Sync) > 0) {
+		v.errorf("", "ipvs-unavailable", "ipvs is not available in the running kernel (no IPVS netlink family). Try ipvsctl doctor")
+		return
+	}
+
+	if caps.Schedulers != nil {
+		ipvsconfig.validateSchedulers(v, caps.Schedulers)
+	}
 
+	for idx, service := range ipvsconfig.Services {
+		if service.PEName != "" && !caps.HasPEName(service.PEName) {
+			v.errorf(fmt.Sprintf("services[%d].pe", idx), "pe-unavailable", "persistence engine %s for service (%s) is not available in the running kernel (no module ip_vs_pe_%s).", service.PEName, service.Address, service.PEName)
 		}
 	}
+}
 
-	return nil
+// ValidateSchedulers checks that all schedulers used by services and defaults
+// are contained in available, e.g. the result of ipvs.AvailableSchedulers.
+func (ipvsconfig *IPVSConfig) ValidateSchedulers(available []string) error {
+	v := &validation{}
+	ipvsconfig.validateSchedulers(v, available)
+	return v.err()
+}
+
+func (ipvsconfig *IPVSConfig) validateSchedulers(v *validation, available []string) {
+	if ipvsconfig.Defaults.SchedName != nil && *ipvsconfig.Defaults.SchedName != "" && !contains(available, *ipvsconfig.Defaults.SchedName) {
+		v.errorf("defaults.sched", "scheduler-unavailable", "default scheduler %s is not available in the running kernel (no module ip_vs_%s).", *ipvsconfig.Defaults.SchedName, *ipvsconfig.Defaults.SchedName)
+	}
+	for idx, service := range ipvsconfig.Services {
+		schedName := service.SchedName
+		if schedName == "" {
+			if ipvsconfig.Defaults.SchedName != nil && *ipvsconfig.Defaults.SchedName != "" {
+				// already checked above
+				continue
+			}
+			// same fallback as NewIpvsServiceStruct
+			schedName = "rr"
+		}
+		if !contains(available, schedName) {
+			v.errorf(fmt.Sprintf("services[%d].sched", idx), "scheduler-unavailable", "scheduler %s for service (%s) is not available in the running kernel (no module ip_vs_%s).", schedName, service.Address, schedName)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
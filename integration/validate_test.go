@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/aschmidt75/ipvsctl/ipvs"
+	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
 )
 
@@ -28,6 +30,41 @@ services:
 - address: tcp://127.0.0.5:9876
   sched: rr
 `, true},
+		{`
+services:
+- address: tcp://[2001:db8::1]:80
+  sched: rr
+  destinations:
+  - address: "[2001:db8::10]:8080"
+    forward: nat
+  - address: "[2001:db8::11]:8080"
+    forward: nat
+`, true},
+		{`
+services:
+- address: tcp://[2001:db8::1]:80
+  destinations:
+  - address: 10.0.0.1:8080
+    forward: nat
+`, false},
+		{`
+services:
+- address: tcp://[2001:db8::1]:80
+  destinations:
+  - address: 10.0.0.1:8080
+    forward: tunnel
+- address: tcp://10.0.0.1:80
+  destinations:
+  - address: "[2001:db8::10]:8080"
+    forward: tunnel
+`, true},
+		{`
+services:
+- address: fwmark:1
+  destinations:
+  - address: "[2001:db8::10]:8080"
+    forward: direct
+`, false},
 		{`services:
 - address: tcp://127.0.0.1:9876
   sched: nosuchsched
@@ -159,6 +196,412 @@ defaults:
   weight: 100
   sched: rr
   forward: nosuchforward
+`, false},
+		{`
+defaults:
+  forward: tunnel
+services:
+- address: tcp://[2001:db8::1]:80
+  destinations:
+  - address: 10.0.0.1:80
+`, true},
+		{`
+defaults:
+  forward: nat
+services:
+- address: tcp://[2001:db8::1]:80
+  destinations:
+  - address: 10.0.0.1:80
+`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			err := validate(t, test.model)
+			if err == nil {
+				if !test.ok {
+					t.Error("Should have returned a validation error, but did not")
+				}
+			} else {
+				if test.ok {
+					t.Error("Should have passed but returned a validation error: %w", err)
+
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePersistence(t *testing.T) {
+
+	var tests = []struct {
+		model string
+		ok    bool
+	}{
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+- address: tcp://[2001:db8::1]:443
+  persistent: 600
+  persistence-netmask: 64
+`, true},
+		{`
+defaults:
+  persistent: 5m
+  persistence-netmask: 24
+services:
+- address: tcp://127.0.0.1:443
+`, true},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: forever
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 300s
+  persistence-netmask: 255.0.255.0
+`, false},
+		{`
+services:
+- address: tcp://[2001:db8::1]:443
+  persistent: 300s
+  persistence-netmask: 255.255.255.0
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 300s
+  persistence-netmask: 33
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistence-netmask: 24
+`, false},
+		{`
+defaults:
+  persistent: -5s
+`, false},
+		{`
+defaults:
+  persistent: 0s
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 500ms
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 1500ms
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 0
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:443
+  persistent: 2000ms
+`, true},
+		{`
+services:
+- address: udp://127.0.0.1:5060
+  persistent: 30m
+  pe: sip
+`, true},
+		{`
+services:
+- address: udp://127.0.0.1:5060
+  pe: sip
+`, false},
+		{`
+services:
+- address: udp://127.0.0.1:5060
+  persistent: 30m
+  pe: nosuchpe
+`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			err := validate(t, test.model)
+			if err == nil {
+				if !test.ok {
+					t.Error("Should have returned a validation error, but did not")
+				}
+			} else {
+				if test.ok {
+					t.Error("Should have passed but returned a validation error: %w", err)
+
+				}
+			}
+		})
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+
+	var tests = []struct {
+		model string
+		ok    bool
+	}{
+		{`
+services:
+- address: udp://127.0.0.1:53
+  sched: sh
+  flags: [sh-fallback, sh-port, one-packet]
+- address: tcp://127.0.0.1:80
+  flags: [flag-1]
+`, true},
+		{`
+defaults:
+  sched: sh
+services:
+- address: tcp://127.0.0.1:80
+  flags: [sh-port]
+`, true},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  sched: rr
+  flags: [sh-port]
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  sched: sh
+  flags: [nosuchflag]
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  flags: [ops]
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  sched: mh
+  flags: [mh-fallback, mh-port]
+- address: tcp://127.0.0.1:81
+  sched: fo
+- address: tcp://127.0.0.1:82
+  sched: ovf
+- address: udp://127.0.0.1:83
+  sched: twos
+  flags: [ops]
+`, true},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  sched: sh
+  flags: [mh-port]
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  sched: fo
+  flags: [flag-1]
+`, false},
+		{`
+defaults:
+  sched: twos
+services:
+- address: tcp://127.0.0.1:80
+  flags: [flag-2]
+`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			err := validate(t, test.model)
+			if err == nil {
+				if !test.ok {
+					t.Error("Should have returned a validation error, but did not")
+				}
+			} else {
+				if test.ok {
+					t.Error("Should have passed but returned a validation error: %w", err)
+
+				}
+			}
+		})
+	}
+}
+
+func TestValidateThresholds(t *testing.T) {
+
+	var tests = []struct {
+		model string
+		ok    bool
+	}{
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:8080
+    forward: nat
+    max-connections: 1000
+    min-connections: 800
+`, true},
+		{`
+defaults:
+  max-connections: 1000
+  min-connections: 800
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:8080
+    forward: nat
+    min-connections: 900
+`, true},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:8080
+    forward: nat
+    max-connections: -1
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:8080
+    forward: nat
+    max-connections: 100
+    min-connections: 200
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:8080
+    forward: nat
+    min-connections: 200
+`, false},
+		{`
+defaults:
+  min-connections: -1
+`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			err := validate(t, test.model)
+			if err == nil {
+				if !test.ok {
+					t.Error("Should have returned a validation error, but did not")
+				}
+			} else {
+				if test.ok {
+					t.Error("Should have passed but returned a validation error: %w", err)
+
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTunnel(t *testing.T) {
+
+	var tests = []struct {
+		model string
+		ok    bool
+	}{
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: tunnel
+    tunnel:
+      type: gue
+      port: 6080
+      checksum: remcsum
+  - address: 127.0.0.3:80
+    forward: tunnel
+    tunnel:
+      type: gre
+      checksum: csum
+  - address: 127.0.0.4:80
+    forward: tunnel
+    tunnel:
+      type: ipip
+`, true},
+		{`
+defaults:
+  forward: tunnel
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    tunnel:
+      type: gue
+      port: 6080
+`, true},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: nat
+    tunnel:
+      type: gue
+      port: 6080
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: tunnel
+    tunnel:
+      type: vxlan
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: tunnel
+    tunnel:
+      type: gue
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: tunnel
+    tunnel:
+      type: gre
+      port: 6080
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: tunnel
+    tunnel:
+      type: gre
+      checksum: remcsum
+`, false},
+		{`
+services:
+- address: tcp://127.0.0.1:80
+  destinations:
+  - address: 127.0.0.2:80
+    forward: tunnel
+    tunnel:
+      checksum: csum
 `, false},
 	}
 
@@ -179,6 +622,254 @@ defaults:
 	}
 }
 
+func TestValidateSync(t *testing.T) {
+
+	var tests = []struct {
+		model string
+		ok    bool
+	}{
+		{`
+sync:
+- state: master
+  interface: eth0
+  sync-id: 10
+- state: backup
+  interface: eth1
+  sync-id: 10
+  group: 239.1.2.3
+  port: 9000
+  ttl: 2
+`, true},
+		{`
+sync:
+- state: nosuchstate
+  interface: eth0
+`, false},
+		{`
+sync:
+- state: master
+  interface: eth0
+- state: master
+  interface: eth1
+`, false},
+		{`
+sync:
+- state: master
+`, false},
+		{`
+sync:
+- state: master
+  interface: eth0
+  sync-id: 300
+`, false},
+		{`
+sync:
+- state: master
+  interface: eth0
+  group: 10.0.0.1
+`, false},
+		{`
+sync:
+- state: master
+  interface: eth0
+  ttl: 256
+`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			err := validate(t, test.model)
+			if err == nil {
+				if !test.ok {
+					t.Error("Should have returned a validation error, but did not")
+				}
+			} else {
+				if test.ok {
+					t.Error("Should have passed but returned a validation error: %w", err)
+
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTimeouts(t *testing.T) {
+
+	var tests = []struct {
+		model string
+		ok    bool
+	}{
+		{`
+timeouts:
+  tcp: 15m
+  tcpfin: 120
+  udp: 5m30s
+`, true},
+		{`
+timeouts:
+  udp: 300s
+`, true},
+		{`
+timeouts:
+  tcp: forever
+`, false},
+		{`
+timeouts:
+  tcpfin: 0s
+`, false},
+		{`
+timeouts:
+  udp: 500ms
+`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			err := validate(t, test.model)
+			if err == nil {
+				if !test.ok {
+					t.Error("Should have returned a validation error, but did not")
+				}
+			} else {
+				if test.ok {
+					t.Error("Should have passed but returned a validation error: %w", err)
+
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSchedulers(t *testing.T) {
+	var config integration.IPVSConfig
+	if err := yaml.Unmarshal([]byte(`
+defaults:
+  sched: wlc
+services:
+- address: tcp://127.0.0.1:80
+  sched: mh
+- address: tcp://127.0.0.1:81
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, config.ValidateSchedulers([]string{"rr", "wlc", "mh"}))
+	assert.NotNil(t, config.ValidateSchedulers([]string{"rr", "wlc"}))
+	assert.NotNil(t, config.ValidateSchedulers([]string{"rr", "mh"}))
+
+	// without sched in service and defaults, the service uses rr
+	config = integration.IPVSConfig{}
+	if err := yaml.Unmarshal([]byte(`
+services:
+- address: tcp://127.0.0.1:80
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, config.ValidateSchedulers([]string{"rr"}))
+	assert.NotNil(t, config.ValidateSchedulers([]string{"wlc"}))
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	const model = `
+services:
+- address: udp://127.0.0.1:5060
+  sched: mh
+  persistent: 60
+  pe: sip
+`
+
+	var tests = []struct {
+		caps *ipvs.Capabilities
+		ok   bool
+	}{
+		{nil, true},
+		{&ipvs.Capabilities{FamilyPresent: true}, true},
+		{&ipvs.Capabilities{FamilyPresent: true, Schedulers: []string{"rr", "mh"}, PENames: []string{"sip"}}, true},
+		{&ipvs.Capabilities{FamilyPresent: false}, false},
+		{&ipvs.Capabilities{FamilyPresent: true, Schedulers: []string{"rr"}}, false},
+		{&ipvs.Capabilities{FamilyPresent: true, PENames: []string{}}, false},
+	}
+
+	for _, test := range tests {
+		var config integration.IPVSConfig
+		if err := yaml.Unmarshal([]byte(model), &config); err != nil {
+			t.Fatal(err)
+		}
+		err := config.WithCapabilities(test.caps).Validate()
+		if test.ok {
+			assert.Nil(t, err, "capabilities %#v", test.caps)
+		} else {
+			assert.NotNil(t, err, "capabilities %#v", test.caps)
+		}
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	var config integration.IPVSConfig
+	if err := yaml.Unmarshal([]byte(`
+defaults:
+  port: 99887766
+services:
+- address: tcp://127.0.0.1:80
+  sched: xrr
+  destinations:
+  - address: 127.0.0.2:1234
+    weight: 100
+  - address: 127.0.0.3:1234
+    weight: 89647
+    forward: nsf
+  - address: 127.0.0.2:1234
+- address: udp://127.0.0.1:53
+sync:
+- state: master
+  ttl: 256
+timeouts:
+  tcp: 0s
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	type issue struct {
+		path     string
+		severity integration.ValidationSeverity
+		code     string
+	}
+	var issues []issue
+	for _, i := range config.ValidateAll() {
+		issues = append(issues, issue{i.Path, i.Severity, i.Code})
+	}
+	assert.Equal(t, []issue{
+		{"defaults.port", integration.SeverityError, "invalid-port"},
+		{"services[0].sched", integration.SeverityError, "invalid-scheduler"},
+		{"services[0].destinations[1].forward", integration.SeverityError, "invalid-forward"},
+		{"services[0].destinations[1].weight", integration.SeverityError, "invalid-weight"},
+		{"services[0].destinations[2].address", integration.SeverityError, "duplicate-address"},
+		{"services[0].destinations[2].weight", integration.SeverityWarning, "zero-weight"},
+		{"services[1].destinations", integration.SeverityWarning, "no-destinations"},
+		{"sync[0].interface", integration.SeverityError, "empty-interface"},
+		{"sync[0].ttl", integration.SeverityError, "invalid-ttl"},
+		{"timeouts.tcp", integration.SeverityError, "invalid-timeout"},
+	}, issues)
+
+	err := config.Validate()
+	validateErr, ok := err.(*integration.IPVSValidateError)
+	if assert.True(t, ok) {
+		assert.Len(t, validateErr.Issues, 10)
+		assert.Equal(t, "Configuration not valid: Default port out of range: 99887766 (and 7 more errors)", validateErr.Error())
+	}
+
+	// warnings alone pass
+	config = integration.IPVSConfig{}
+	if err := yaml.Unmarshal([]byte(`
+services:
+- address: tcp://127.0.0.1:80
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, config.ValidateAll(), 1)
+	assert.Nil(t, config.Validate())
+}
+
 func validate(t *testing.T, model string) error {
 	var err error
 	var config integration.IPVSConfig
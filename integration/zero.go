@@ -0,0 +1,64 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+
+	ipvs "github.com/aschmidt75/ipvsctl/ipvs"
+)
+
+// IPVSZeroError signals an error when zeroing counters
+type IPVSZeroError struct {
+	what    string
+	origErr error
+}
+
+func (e *IPVSZeroError) Error() string {
+	if e.origErr == nil {
+		return fmt.Sprintf("Unable to zero counters: %s", e.what)
+	}
+	return fmt.Sprintf("Unable to zero counters: %s\nReason: %s", e.what, e.origErr)
+}
+
+// Unwrap returns the underlying error, e.g. an ipvs.TimeoutError
+func (e *IPVSZeroError) Unwrap() error {
+	return e.origErr
+}
+
+// Zero resets the statistics counters of the service given by its handle,
+// e.g. tcp://10.0.0.1:80. If serviceName is empty, counters of all services
+// are reset.
+func (ipvsconfig *IPVSConfig) Zero(serviceName string) error {
+	return ipvsconfig.ZeroContext(context.Background(), serviceName)
+}
+
+// ZeroContext is like Zero, but honours the deadline and cancellation of ctx.
+func (ipvsconfig *IPVSConfig) ZeroContext(ctx context.Context, serviceName string) error {
+	var service *ipvs.Service
+
+	if serviceName != "" {
+		s, _ := ipvsconfig.LocateServiceAndDestination(serviceName, "")
+		if s == nil {
+			return &IPVSZeroError{what: fmt.Sprintf("Service %s not found in active ipvs configuration. Try ipvsctl get", serviceName)}
+		}
+		service = s.service
+	}
+
+	ipvs, err := ipvsconfig.newHandle()
+	if err != nil {
+		return &IPVSHandleError{origErr: err}
+	}
+	defer ipvs.Close()
+
+	err = ipvs.ZeroContext(ctx, service)
+	if err != nil {
+		return &IPVSZeroError{what: "zero command failed", origErr: err}
+	}
+
+	if serviceName == "" {
+		ipvsconfig.log.Printf("Zeroed counters of all services\n")
+	} else {
+		ipvsconfig.log.Printf("Zeroed counters of %s\n", serviceName)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package integration_test
+
+import (
+	"testing"
+
+	integration "github.com/aschmidt75/ipvsctl/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZeroUnknownService(t *testing.T) {
+	c := integration.NewIPVSConfig()
+
+	err := c.Zero("tcp://127.0.0.1:9876")
+	assert.Error(t, err)
+	assert.IsType(t, &integration.IPVSZeroError{}, err)
+}
@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import "context"
+
+// Backend covers all operations on ipvs tables. It is implemented by Handle,
+// which talks to the kernel via netlink, and by Memory, which keeps all tables
+// in memory. All operations have a variant taking a context, which returns
+// a TimeoutError when the context is done before the operation completes.
+type Backend interface {
+	Close()
+
+	NewService(s *Service) error
+	IsServicePresent(s *Service) bool
+	UpdateService(s *Service) error
+	DelService(s *Service) error
+	Flush() error
+	Zero(s *Service) error
+	GetServices() ([]*Service, error)
+	GetService(s *Service) (*Service, error)
+
+	NewDestination(s *Service, d *Destination) error
+	UpdateDestination(s *Service, d *Destination) error
+	DelDestination(s *Service, d *Destination) error
+	GetDestinations(s *Service) ([]*Destination, error)
+
+	NewDaemon(d *Daemon) error
+	DelDaemon(d *Daemon) error
+	GetDaemons() ([]*Daemon, error)
+
+	GetConfig() (*Config, error)
+	SetConfig(c *Config) error
+
+	NewServiceContext(ctx context.Context, s *Service) error
+	IsServicePresentContext(ctx context.Context, s *Service) bool
+	UpdateServiceContext(ctx context.Context, s *Service) error
+	DelServiceContext(ctx context.Context, s *Service) error
+	FlushContext(ctx context.Context) error
+	ZeroContext(ctx context.Context, s *Service) error
+	GetServicesContext(ctx context.Context) ([]*Service, error)
+	GetServiceContext(ctx context.Context, s *Service) (*Service, error)
+
+	NewDestinationContext(ctx context.Context, s *Service, d *Destination) error
+	UpdateDestinationContext(ctx context.Context, s *Service, d *Destination) error
+	DelDestinationContext(ctx context.Context, s *Service, d *Destination) error
+	GetDestinationsContext(ctx context.Context, s *Service) ([]*Destination, error)
+
+	NewDaemonContext(ctx context.Context, d *Daemon) error
+	DelDaemonContext(ctx context.Context, d *Daemon) error
+	GetDaemonsContext(ctx context.Context) ([]*Daemon, error)
+
+	GetConfigContext(ctx context.Context) (*Config, error)
+	SetConfigContext(ctx context.Context, c *Config) error
+}
+
+var (
+	_ Backend = (*Handle)(nil)
+	_ Backend = (*Memory)(nil)
+)
@@ -72,6 +72,7 @@ const (
 	ipvsSvcAttrNetmask
 	ipvsSvcAttrStats
 	ipvsSvcAttrPEName
+	ipvsSvcAttrStats64
 )
 
 // Attributes used to describe a destination (real server). Used
@@ -89,6 +90,24 @@ const (
 	ipvsDestAttrPersistentConnections
 	ipvsDestAttrStats
 	ipvsDestAttrAddressFamily
+	ipvsDestAttrStats64
+	ipvsDestAttrTunType
+	ipvsDestAttrTunPort
+	ipvsDestAttrTunFlags
+)
+
+// Attributes used to describe a connection synchronisation daemon. Used
+// inside nested attribute ipvsCmdAttrDaemon.
+const (
+	ipvsDaemonAttrUnspec int = iota
+	ipvsDaemonAttrState
+	ipvsDaemonAttrMcastIfn
+	ipvsDaemonAttrSyncID
+	ipvsDaemonAttrSyncMaxLen
+	ipvsDaemonAttrMcastGroup
+	ipvsDaemonAttrMcastGroup6
+	ipvsDaemonAttrMcastPort
+	ipvsDaemonAttrMcastTTL
 )
 
 // IPVS Svc Statistics constancs
@@ -129,6 +148,60 @@ const (
 	ConnectionFlagDirectRoute = 0x0003
 )
 
+// Tunnel types of tunnel-forwarded destinations
+const (
+	// TunnelTypeIPIP denotes plain IPIP encapsulation
+	TunnelTypeIPIP = 0
+
+	// TunnelTypeGUE denotes generic UDP encapsulation
+	TunnelTypeGUE = 1
+
+	// TunnelTypeGRE denotes generic routing encapsulation
+	TunnelTypeGRE = 2
+)
+
+// Tunnel encapsulation flags
+const (
+	// TunnelFlagNoCsum disables checksums in the encapsulation header
+	TunnelFlagNoCsum = 0x0000
+
+	// TunnelFlagCsum enables checksums in the encapsulation header
+	TunnelFlagCsum = 0x0001
+
+	// TunnelFlagRemCsum enables remote checksum offload (gue only)
+	TunnelFlagRemCsum = 0x0002
+)
+
+// Service flags
+const (
+	// SvcFlagPersistent marks a service as persistent (sticky)
+	SvcFlagPersistent = 0x0001
+
+	// SvcFlagHashed is set by the kernel for services in its hash tables
+	SvcFlagHashed = 0x0002
+
+	// SvcFlagOnePacket enables one-packet scheduling (udp only)
+	SvcFlagOnePacket = 0x0004
+
+	// SvcFlagSched1 is the first scheduler specific flag (sh-fallback, mh-fallback)
+	SvcFlagSched1 = 0x0008
+
+	// SvcFlagSched2 is the second scheduler specific flag (sh-port, mh-port)
+	SvcFlagSched2 = 0x0010
+
+	// SvcFlagSched3 is the third scheduler specific flag
+	SvcFlagSched3 = 0x0020
+)
+
+// Connection synchronisation daemon states
+const (
+	// DaemonStateMaster denotes a daemon sending connection updates
+	DaemonStateMaster = 0x0001
+
+	// DaemonStateBackup denotes a daemon receiving connection updates
+	DaemonStateBackup = 0x0002
+)
+
 const (
 	// RoundRobin distributes jobs equally amongst the available
 	// real servers.
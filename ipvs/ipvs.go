@@ -8,6 +8,7 @@
 package ipvs
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -20,8 +21,31 @@ import (
 const (
 	netlinkRecvSocketsTimeout = 3 * time.Second
 	netlinkSendSocketTimeout  = 30 * time.Second
+
+	// pollInterval is the maximum time a request waits for a response
+	// before checking its context again
+	pollInterval = 100 * time.Millisecond
 )
 
+// TimeoutError is returned by requests whose context got cancelled or
+// exceeded its deadline before the kernel responded
+type TimeoutError struct {
+	Err error // context.Canceled or context.DeadlineExceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("ipvs request timed out: %v", e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout returns true, so that TimeoutError satisfies net.Error-like checks
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
 // Service defines an IPVS service in its entirety.
 type Service struct {
 	// Virtual service address.
@@ -40,18 +64,19 @@ type Service struct {
 	Stats         SvcStats
 }
 
-// SvcStats defines an IPVS service statistics
+// SvcStats defines an IPVS service statistics. Counters are taken from
+// the kernel's 64-bit statistics if available, from the 32-bit ones otherwise.
 type SvcStats struct {
-	Connections uint32
-	PacketsIn   uint32
-	PacketsOut  uint32
+	Connections uint64
+	PacketsIn   uint64
+	PacketsOut  uint64
 	BytesIn     uint64
 	BytesOut    uint64
-	CPS         uint32
-	BPSOut      uint32
-	PPSIn       uint32
-	PPSOut      uint32
-	BPSIn       uint32
+	CPS         uint64
+	BPSOut      uint64
+	PPSIn       uint64
+	PPSOut      uint64
+	BPSIn       uint64
 }
 
 // Destination defines an IPVS destination (real server) in its
@@ -67,11 +92,25 @@ type Destination struct {
 	ActiveConnections   int
 	InactiveConnections int
 	Stats               DstStats
+	TunnelType          uint8  // ipip=0, gue=1, gre=2
+	TunnelPort          uint16 // udp port for gue
+	TunnelFlags         uint16 // csum=1, remcsum=2
 }
 
 // DstStats defines IPVS destination (real server) statistics
 type DstStats SvcStats
 
+// Daemon defines an IPVS connection synchronisation daemon
+type Daemon struct {
+	State      uint32 // master=1, backup=2
+	McastIfn   string // multicast interface name
+	SyncID     uint32
+	SyncMaxLen uint16 // udp payload size, 0=kernel default
+	McastGroup net.IP // multicast group, nil=kernel default
+	McastPort  uint16 // multicast port, 0=kernel default
+	McastTTL   uint8  // multicast ttl, 0=kernel default
+}
+
 // Config defines IPVS timeout configuration
 type Config struct {
 	TimeoutTCP    time.Duration
@@ -80,17 +119,27 @@ type Config struct {
 }
 
 // Handle provides a namespace specific ipvs handle to program ipvs
-// rules.
+// rules. It is safe for concurrent use, but requests are serialized
+// on its socket. Use several handles for parallel requests.
 type Handle struct {
 	seq  uint32
+	lock chan struct{} // held while a request is in flight
 	sock *nl.NetlinkSocket
 }
 
 // New provides a new ipvs handle in the namespace pointed to by the
 // passed path. It will return a valid handle or an error in case an
-// error occurred while creating the handle.
-func New(path string) (*Handle, error) {
-	setup()
+// error occurred while creating the handle. Errors regarding the
+// kernel module are of type ModuleLoadError, ModuleNotLoadedError,
+// FamilyNotFoundError or FamilyError.
+func New(path string, opts ...Option) (*Handle, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := setup(o); err != nil {
+		return nil, err
+	}
 
 	n := netns.None()
 	if path != "" {
@@ -116,7 +165,7 @@ func New(path string) (*Handle, error) {
 		return nil, err
 	}
 
-	return &Handle{sock: sock}, nil
+	return &Handle{sock: sock, lock: make(chan struct{}, 1)}, nil
 }
 
 // Close closes the ipvs handle. The handle is invalid after Close
@@ -129,65 +178,147 @@ func (i *Handle) Close() {
 
 // NewService creates a new ipvs service in the passed handle.
 func (i *Handle) NewService(s *Service) error {
-	return i.doCmd(s, nil, ipvsCmdNewService)
+	return i.NewServiceContext(context.Background(), s)
+}
+
+// NewServiceContext is like NewService, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) NewServiceContext(ctx context.Context, s *Service) error {
+	return i.doCmd(ctx, s, nil, ipvsCmdNewService)
 }
 
 // IsServicePresent queries for the ipvs service in the passed handle.
 func (i *Handle) IsServicePresent(s *Service) bool {
-	return nil == i.doCmd(s, nil, ipvsCmdGetService)
+	return i.IsServicePresentContext(context.Background(), s)
+}
+
+// IsServicePresentContext is like IsServicePresent, but honours the
+// deadline and cancellation of ctx.
+func (i *Handle) IsServicePresentContext(ctx context.Context, s *Service) bool {
+	return nil == i.doCmd(ctx, s, nil, ipvsCmdGetService)
 }
 
 // UpdateService updates an already existing service in the passed
 // handle.
 func (i *Handle) UpdateService(s *Service) error {
-	return i.doCmd(s, nil, ipvsCmdSetService)
+	return i.UpdateServiceContext(context.Background(), s)
+}
+
+// UpdateServiceContext is like UpdateService, but honours the deadline
+// and cancellation of ctx.
+func (i *Handle) UpdateServiceContext(ctx context.Context, s *Service) error {
+	return i.doCmd(ctx, s, nil, ipvsCmdSetService)
 }
 
 // DelService deletes an already existing service in the passed
 // handle.
 func (i *Handle) DelService(s *Service) error {
-	return i.doCmd(s, nil, ipvsCmdDelService)
+	return i.DelServiceContext(context.Background(), s)
+}
+
+// DelServiceContext is like DelService, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) DelServiceContext(ctx context.Context, s *Service) error {
+	return i.doCmd(ctx, s, nil, ipvsCmdDelService)
 }
 
 // Flush deletes all existing services in the passed
 // handle.
 func (i *Handle) Flush() error {
-	_, err := i.doCmdWithoutAttr(ipvsCmdFlush)
+	return i.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, but honours the deadline and cancellation
+// of ctx.
+func (i *Handle) FlushContext(ctx context.Context) error {
+	_, err := i.doCmdWithoutAttr(ctx, ipvsCmdFlush)
 	return err
 }
 
+// Zero resets the statistics counters of the passed service and its
+// destinations. If s is nil, counters of all services are reset.
+func (i *Handle) Zero(s *Service) error {
+	return i.ZeroContext(context.Background(), s)
+}
+
+// ZeroContext is like Zero, but honours the deadline and cancellation
+// of ctx.
+func (i *Handle) ZeroContext(ctx context.Context, s *Service) error {
+	if s == nil {
+		_, err := i.doCmdWithoutAttr(ctx, ipvsCmdZero)
+		return err
+	}
+	return i.doCmd(ctx, s, nil, ipvsCmdZero)
+}
+
 // NewDestination creates a new real server in the passed ipvs
 // service which should already be existing in the passed handle.
 func (i *Handle) NewDestination(s *Service, d *Destination) error {
-	return i.doCmd(s, d, ipvsCmdNewDest)
+	return i.NewDestinationContext(context.Background(), s, d)
+}
+
+// NewDestinationContext is like NewDestination, but honours the deadline
+// and cancellation of ctx.
+func (i *Handle) NewDestinationContext(ctx context.Context, s *Service, d *Destination) error {
+	return i.doCmd(ctx, s, d, ipvsCmdNewDest)
 }
 
 // UpdateDestination updates an already existing real server in the
 // passed ipvs service in the passed handle.
 func (i *Handle) UpdateDestination(s *Service, d *Destination) error {
-	return i.doCmd(s, d, ipvsCmdSetDest)
+	return i.UpdateDestinationContext(context.Background(), s, d)
+}
+
+// UpdateDestinationContext is like UpdateDestination, but honours the
+// deadline and cancellation of ctx.
+func (i *Handle) UpdateDestinationContext(ctx context.Context, s *Service, d *Destination) error {
+	return i.doCmd(ctx, s, d, ipvsCmdSetDest)
 }
 
 // DelDestination deletes an already existing real server in the
 // passed ipvs service in the passed handle.
 func (i *Handle) DelDestination(s *Service, d *Destination) error {
-	return i.doCmd(s, d, ipvsCmdDelDest)
+	return i.DelDestinationContext(context.Background(), s, d)
+}
+
+// DelDestinationContext is like DelDestination, but honours the deadline
+// and cancellation of ctx.
+func (i *Handle) DelDestinationContext(ctx context.Context, s *Service, d *Destination) error {
+	return i.doCmd(ctx, s, d, ipvsCmdDelDest)
 }
 
 // GetServices returns an array of services configured on the Node
 func (i *Handle) GetServices() ([]*Service, error) {
-	return i.doGetServicesCmd(nil)
+	return i.GetServicesContext(context.Background())
+}
+
+// GetServicesContext is like GetServices, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) GetServicesContext(ctx context.Context) ([]*Service, error) {
+	return i.doGetServicesCmd(ctx, nil)
 }
 
 // GetDestinations returns an array of Destinations configured for this Service
 func (i *Handle) GetDestinations(s *Service) ([]*Destination, error) {
-	return i.doGetDestinationsCmd(s, nil)
+	return i.GetDestinationsContext(context.Background(), s)
+}
+
+// GetDestinationsContext is like GetDestinations, but honours the deadline
+// and cancellation of ctx.
+func (i *Handle) GetDestinationsContext(ctx context.Context, s *Service) ([]*Destination, error) {
+	return i.doGetDestinationsCmd(ctx, s, nil)
 }
 
 // GetService gets details of a specific IPVS services, useful in updating statisics etc.,
 func (i *Handle) GetService(s *Service) (*Service, error) {
+	return i.GetServiceContext(context.Background(), s)
+}
 
-	res, err := i.doGetServicesCmd(s)
+// GetServiceContext is like GetService, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) GetServiceContext(ctx context.Context, s *Service) (*Service, error) {
+
+	res, err := i.doGetServicesCmd(ctx, s)
 	if err != nil {
 		return nil, err
 	}
@@ -200,12 +331,62 @@ func (i *Handle) GetService(s *Service) (*Service, error) {
 	return res[0], nil
 }
 
+// NewDaemon starts a new connection synchronisation daemon in the
+// passed handle.
+func (i *Handle) NewDaemon(d *Daemon) error {
+	return i.NewDaemonContext(context.Background(), d)
+}
+
+// NewDaemonContext is like NewDaemon, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) NewDaemonContext(ctx context.Context, d *Daemon) error {
+	_, err := i.doDaemonCmd(ctx, d, ipvsCmdNewDaemon)
+	return err
+}
+
+// DelDaemon stops the connection synchronisation daemon with the
+// state (master or backup) of the passed daemon.
+func (i *Handle) DelDaemon(d *Daemon) error {
+	return i.DelDaemonContext(context.Background(), d)
+}
+
+// DelDaemonContext is like DelDaemon, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) DelDaemonContext(ctx context.Context, d *Daemon) error {
+	_, err := i.doDaemonCmd(ctx, d, ipvsCmdDelDaemon)
+	return err
+}
+
+// GetDaemons returns an array of connection synchronisation daemons
+// running on the Node
+func (i *Handle) GetDaemons() ([]*Daemon, error) {
+	return i.GetDaemonsContext(context.Background())
+}
+
+// GetDaemonsContext is like GetDaemons, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) GetDaemonsContext(ctx context.Context) ([]*Daemon, error) {
+	return i.doGetDaemonsCmd(ctx)
+}
+
 // GetConfig returns the current timeout configuration
 func (i *Handle) GetConfig() (*Config, error) {
-	return i.doGetConfigCmd()
+	return i.GetConfigContext(context.Background())
+}
+
+// GetConfigContext is like GetConfig, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) GetConfigContext(ctx context.Context) (*Config, error) {
+	return i.doGetConfigCmd(ctx)
 }
 
 // SetConfig set the current timeout configuration. 0: no change
 func (i *Handle) SetConfig(c *Config) error {
-	return i.doSetConfigCmd(c)
+	return i.SetConfigContext(context.Background(), c)
+}
+
+// SetConfigContext is like SetConfig, but honours the deadline and
+// cancellation of ctx.
+func (i *Handle) SetConfigContext(ctx context.Context, c *Config) error {
+	return i.doSetConfigCmd(ctx, c)
 }
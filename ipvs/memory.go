@@ -0,0 +1,676 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemorySchedulers contains the names of all schedulers known to Memory
+var MemorySchedulers = []string{"rr", "wrr", "lc", "wlc", "lblc", "lblcr", "dh", "sh", "sed", "nq", "mh", "fo", "ovf", "twos"}
+
+// MemoryPENames contains the names of all persistence engines known to Memory
+var MemoryPENames = []string{"sip"}
+
+// Default values reported by Memory, taken from the kernel
+const (
+	MemoryDefaultTimeoutTCP    = 900 * time.Second
+	MemoryDefaultTimeoutTCPFin = 120 * time.Second
+	MemoryDefaultTimeoutUDP    = 300 * time.Second
+	MemoryDefaultMcastPort     = 8848
+	MemoryDefaultMcastTTL      = 1
+)
+
+// MemoryDefaultMcastGroup is the multicast group of sync daemons, if not specified
+var MemoryDefaultMcastGroup = net.IPv4(224, 0, 0, 81)
+
+type memoryService struct {
+	service      Service
+	destinations []*Destination
+	index        map[string]*Destination // destinations by destinationKey
+}
+
+// Memory is a Backend which keeps all ipvs tables in memory. It returns the
+// same errors as the kernel does, e.g. syscall.EEXIST when adding a service
+// twice or syscall.ENOENT for a missing destination. Its tables survive Close,
+// so a single Memory can be shared by several IPVSConfig operations.
+// Memory is safe for concurrent use.
+type Memory struct {
+	mu       sync.Mutex
+	services []*memoryService
+	index    map[string]*memoryService // services by serviceKey
+	daemons  map[uint32]*Daemon
+	config   Config
+}
+
+// NewMemory creates an empty in-memory ipvs backend with kernel default timeouts
+func NewMemory() *Memory {
+	return &Memory{
+		index:   make(map[string]*memoryService),
+		daemons: make(map[uint32]*Daemon),
+		config: Config{
+			TimeoutTCP:    MemoryDefaultTimeoutTCP,
+			TimeoutTCPFin: MemoryDefaultTimeoutTCPFin,
+			TimeoutUDP:    MemoryDefaultTimeoutUDP,
+		},
+	}
+}
+
+// Close does nothing, all tables are kept.
+func (m *Memory) Close() {
+}
+
+// NewService creates a new service
+func (m *Memory) NewService(s *Service) error {
+	if err := checkMemoryService(s); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findService(s) != nil {
+		return syscall.EEXIST
+	}
+	ms := &memoryService{
+		service: copyService(s),
+		index:   make(map[string]*Destination),
+	}
+	ms.service.Flags |= SvcFlagHashed
+	ms.service.Stats = SvcStats{}
+	m.services = append(m.services, ms)
+	m.index[serviceKey(s)] = ms
+
+	return nil
+}
+
+// IsServicePresent queries for the service
+func (m *Memory) IsServicePresent(s *Service) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.findService(s) != nil
+}
+
+// UpdateService updates scheduler, flags, timeout, netmask and persistence
+// engine of an existing service
+func (m *Memory) UpdateService(s *Service) error {
+	if err := checkMemoryService(s); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	ms.service.SchedName = s.SchedName
+	ms.service.Flags = s.Flags | SvcFlagHashed
+	ms.service.Timeout = s.Timeout
+	ms.service.Netmask = s.Netmask
+	ms.service.PEName = s.PEName
+
+	return nil
+}
+
+// DelService deletes an existing service including its destinations
+func (m *Memory) DelService(s *Service) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	for idx := range m.services {
+		if m.services[idx] == ms {
+			m.services = append(m.services[:idx], m.services[idx+1:]...)
+			break
+		}
+	}
+	delete(m.index, serviceKey(s))
+	return nil
+}
+
+// Flush deletes all services
+func (m *Memory) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.services = nil
+	m.index = make(map[string]*memoryService)
+	return nil
+}
+
+// Zero resets the statistics counters of the passed service and its
+// destinations. If s is nil, counters of all services are reset.
+func (m *Memory) Zero(s *Service) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s == nil {
+		for _, ms := range m.services {
+			ms.zero()
+		}
+		return nil
+	}
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	ms.zero()
+
+	return nil
+}
+
+// GetServices returns copies of all services
+func (m *Memory) GetServices() ([]*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]*Service, 0, len(m.services))
+	for _, ms := range m.services {
+		s := copyService(&ms.service)
+		res = append(res, &s)
+	}
+	return res, nil
+}
+
+// GetService returns a copy of a single service
+func (m *Memory) GetService(s *Service) (*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return nil, syscall.ESRCH
+	}
+	res := copyService(&ms.service)
+	return &res, nil
+}
+
+// NewDestination adds a destination to an existing service
+func (m *Memory) NewDestination(s *Service, d *Destination) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	dc, err := m.checkDestination(ms, d)
+	if err != nil {
+		return err
+	}
+	if ms.findDestination(&dc) != nil {
+		return syscall.EEXIST
+	}
+	dc.Stats = DstStats{}
+	dc.ActiveConnections = 0
+	dc.InactiveConnections = 0
+	ms.destinations = append(ms.destinations, &dc)
+	ms.index[destinationKey(&dc)] = &dc
+
+	return nil
+}
+
+// UpdateDestination updates an existing destination of an existing service
+func (m *Memory) UpdateDestination(s *Service, d *Destination) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	dc, err := m.checkDestination(ms, d)
+	if err != nil {
+		return err
+	}
+	md := ms.findDestination(&dc)
+	if md == nil {
+		return syscall.ENOENT
+	}
+	md.Weight = dc.Weight
+	md.ConnectionFlags = dc.ConnectionFlags
+	md.UpperThreshold = dc.UpperThreshold
+	md.LowerThreshold = dc.LowerThreshold
+	md.TunnelType = dc.TunnelType
+	md.TunnelPort = dc.TunnelPort
+	md.TunnelFlags = dc.TunnelFlags
+
+	return nil
+}
+
+// DelDestination deletes an existing destination of an existing service
+func (m *Memory) DelDestination(s *Service, d *Destination) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	dc := copyDestination(d)
+	if dc.AddressFamily == 0 {
+		dc.AddressFamily = ms.service.AddressFamily
+	}
+	md := ms.findDestination(&dc)
+	if md == nil {
+		return syscall.ENOENT
+	}
+	for idx := range ms.destinations {
+		if ms.destinations[idx] == md {
+			ms.destinations = append(ms.destinations[:idx], ms.destinations[idx+1:]...)
+			break
+		}
+	}
+	delete(ms.index, destinationKey(&dc))
+	return nil
+}
+
+// GetDestinations returns copies of all destinations of a service
+func (m *Memory) GetDestinations(s *Service) ([]*Destination, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return nil, syscall.ESRCH
+	}
+	res := make([]*Destination, 0, len(ms.destinations))
+	for _, md := range ms.destinations {
+		d := copyDestination(md)
+		res = append(res, &d)
+	}
+	return res, nil
+}
+
+// SetStats sets the statistics counters of a service, or of one of its
+// destinations if d is not nil. It allows for simulating traffic.
+func (m *Memory) SetStats(s *Service, d *Destination, stats SvcStats) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := m.findService(s)
+	if ms == nil {
+		return syscall.ESRCH
+	}
+	if d == nil {
+		ms.service.Stats = stats
+		return nil
+	}
+	dc := copyDestination(d)
+	if dc.AddressFamily == 0 {
+		dc.AddressFamily = ms.service.AddressFamily
+	}
+	md := ms.findDestination(&dc)
+	if md == nil {
+		return syscall.ENOENT
+	}
+	md.Stats = DstStats(stats)
+
+	return nil
+}
+
+// NewDaemon starts a sync daemon. Omitted multicast settings are set to
+// kernel defaults.
+func (m *Memory) NewDaemon(d *Daemon) error {
+	if d.State != DaemonStateMaster && d.State != DaemonStateBackup {
+		return syscall.EINVAL
+	}
+	if d.McastIfn == "" {
+		return syscall.EINVAL
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.daemons[d.State]; exists {
+		return syscall.EEXIST
+	}
+
+	dc := *d
+	dc.McastGroup = copyIP(d.McastGroup)
+	if dc.McastGroup == nil {
+		dc.McastGroup = copyIP(MemoryDefaultMcastGroup)
+	}
+	if dc.McastPort == 0 {
+		dc.McastPort = MemoryDefaultMcastPort
+	}
+	if dc.McastTTL == 0 {
+		dc.McastTTL = MemoryDefaultMcastTTL
+	}
+	m.daemons[d.State] = &dc
+
+	return nil
+}
+
+// DelDaemon stops the sync daemon with the state of d
+func (m *Memory) DelDaemon(d *Daemon) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.daemons[d.State]; !exists {
+		return syscall.ESRCH
+	}
+	delete(m.daemons, d.State)
+
+	return nil
+}
+
+// GetDaemons returns copies of all running sync daemons, master first
+func (m *Memory) GetDaemons() ([]*Daemon, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]*Daemon, 0, len(m.daemons))
+	for _, state := range []uint32{DaemonStateMaster, DaemonStateBackup} {
+		if d, exists := m.daemons[state]; exists {
+			dc := *d
+			dc.McastGroup = copyIP(d.McastGroup)
+			res = append(res, &dc)
+		}
+	}
+	return res, nil
+}
+
+// GetConfig returns the current timeout configuration
+func (m *Memory) GetConfig() (*Config, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.config
+	return &c, nil
+}
+
+// SetConfig set the current timeout configuration. 0: no change
+func (m *Memory) SetConfig(c *Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c.TimeoutTCP != 0 {
+		m.config.TimeoutTCP = c.TimeoutTCP.Truncate(time.Second)
+	}
+	if c.TimeoutTCPFin != 0 {
+		m.config.TimeoutTCPFin = c.TimeoutTCPFin.Truncate(time.Second)
+	}
+	if c.TimeoutUDP != 0 {
+		m.config.TimeoutUDP = c.TimeoutUDP.Truncate(time.Second)
+	}
+
+	return nil
+}
+
+// memoryContextErr returns a TimeoutError if ctx is done. Memory does not
+// block, so its context variants only check ctx before running an operation.
+func memoryContextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{Err: err}
+	}
+	return nil
+}
+
+// NewServiceContext is like NewService, but fails if ctx is done
+func (m *Memory) NewServiceContext(ctx context.Context, s *Service) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.NewService(s)
+}
+
+// IsServicePresentContext is like IsServicePresent, but fails if ctx is done
+func (m *Memory) IsServicePresentContext(ctx context.Context, s *Service) bool {
+	return memoryContextErr(ctx) == nil && m.IsServicePresent(s)
+}
+
+// UpdateServiceContext is like UpdateService, but fails if ctx is done
+func (m *Memory) UpdateServiceContext(ctx context.Context, s *Service) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.UpdateService(s)
+}
+
+// DelServiceContext is like DelService, but fails if ctx is done
+func (m *Memory) DelServiceContext(ctx context.Context, s *Service) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.DelService(s)
+}
+
+// FlushContext is like Flush, but fails if ctx is done
+func (m *Memory) FlushContext(ctx context.Context) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.Flush()
+}
+
+// ZeroContext is like Zero, but fails if ctx is done
+func (m *Memory) ZeroContext(ctx context.Context, s *Service) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.Zero(s)
+}
+
+// GetServicesContext is like GetServices, but fails if ctx is done
+func (m *Memory) GetServicesContext(ctx context.Context) ([]*Service, error) {
+	if err := memoryContextErr(ctx); err != nil {
+		return nil, err
+	}
+	return m.GetServices()
+}
+
+// GetServiceContext is like GetService, but fails if ctx is done
+func (m *Memory) GetServiceContext(ctx context.Context, s *Service) (*Service, error) {
+	if err := memoryContextErr(ctx); err != nil {
+		return nil, err
+	}
+	return m.GetService(s)
+}
+
+// NewDestinationContext is like NewDestination, but fails if ctx is done
+func (m *Memory) NewDestinationContext(ctx context.Context, s *Service, d *Destination) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.NewDestination(s, d)
+}
+
+// UpdateDestinationContext is like UpdateDestination, but fails if ctx is done
+func (m *Memory) UpdateDestinationContext(ctx context.Context, s *Service, d *Destination) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.UpdateDestination(s, d)
+}
+
+// DelDestinationContext is like DelDestination, but fails if ctx is done
+func (m *Memory) DelDestinationContext(ctx context.Context, s *Service, d *Destination) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.DelDestination(s, d)
+}
+
+// GetDestinationsContext is like GetDestinations, but fails if ctx is done
+func (m *Memory) GetDestinationsContext(ctx context.Context, s *Service) ([]*Destination, error) {
+	if err := memoryContextErr(ctx); err != nil {
+		return nil, err
+	}
+	return m.GetDestinations(s)
+}
+
+// NewDaemonContext is like NewDaemon, but fails if ctx is done
+func (m *Memory) NewDaemonContext(ctx context.Context, d *Daemon) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.NewDaemon(d)
+}
+
+// DelDaemonContext is like DelDaemon, but fails if ctx is done
+func (m *Memory) DelDaemonContext(ctx context.Context, d *Daemon) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.DelDaemon(d)
+}
+
+// GetDaemonsContext is like GetDaemons, but fails if ctx is done
+func (m *Memory) GetDaemonsContext(ctx context.Context) ([]*Daemon, error) {
+	if err := memoryContextErr(ctx); err != nil {
+		return nil, err
+	}
+	return m.GetDaemons()
+}
+
+// GetConfigContext is like GetConfig, but fails if ctx is done
+func (m *Memory) GetConfigContext(ctx context.Context) (*Config, error) {
+	if err := memoryContextErr(ctx); err != nil {
+		return nil, err
+	}
+	return m.GetConfig()
+}
+
+// SetConfigContext is like SetConfig, but fails if ctx is done
+func (m *Memory) SetConfigContext(ctx context.Context, c *Config) error {
+	if err := memoryContextErr(ctx); err != nil {
+		return err
+	}
+	return m.SetConfig(c)
+}
+
+func (m *Memory) findService(s *Service) *memoryService {
+	return m.index[serviceKey(s)]
+}
+
+// checkDestination returns a copy of d with the address family defaulted
+// to the one of the service, or the error the kernel would return.
+func (m *Memory) checkDestination(ms *memoryService, d *Destination) (Destination, error) {
+	dc := copyDestination(d)
+	if dc.AddressFamily == 0 {
+		dc.AddressFamily = ms.service.AddressFamily
+	}
+	if !isValidFamilyAddress(dc.AddressFamily, dc.Address) {
+		return dc, syscall.EINVAL
+	}
+	if dc.AddressFamily != ms.service.AddressFamily {
+		// mixed families are incompatible with connection sync and only work with tunneling
+		if len(m.daemons) > 0 || dc.ConnectionFlags&ConnectionFlagFwdMask != ConnectionFlagTunnel {
+			return dc, syscall.EINVAL
+		}
+	}
+	if dc.Weight < 0 {
+		return dc, syscall.ERANGE
+	}
+	if dc.LowerThreshold > dc.UpperThreshold {
+		return dc, syscall.ERANGE
+	}
+	if dc.TunnelType == TunnelTypeGUE && dc.TunnelPort == 0 {
+		return dc, syscall.EINVAL
+	}
+	return dc, nil
+}
+
+func (ms *memoryService) findDestination(d *Destination) *Destination {
+	return ms.index[destinationKey(d)]
+}
+
+func (ms *memoryService) zero() {
+	ms.service.Stats = SvcStats{}
+	for _, md := range ms.destinations {
+		md.Stats = DstStats{}
+	}
+}
+
+// checkMemoryService returns the error the kernel would return for an invalid service
+func checkMemoryService(s *Service) error {
+	if s.AddressFamily != syscall.AF_INET && s.AddressFamily != syscall.AF_INET6 {
+		return syscall.EAFNOSUPPORT
+	}
+	if s.FWMark == 0 {
+		switch s.Protocol {
+		case syscall.IPPROTO_TCP, syscall.IPPROTO_UDP, syscall.IPPROTO_SCTP:
+		default:
+			return syscall.EPROTONOSUPPORT
+		}
+		if !isValidFamilyAddress(s.AddressFamily, s.Address) {
+			return syscall.EINVAL
+		}
+	}
+	if s.AddressFamily == syscall.AF_INET6 && (s.Netmask < 1 || s.Netmask > 128) {
+		return syscall.EINVAL
+	}
+	if !contains(MemorySchedulers, s.SchedName) {
+		return syscall.ENOENT
+	}
+	if s.PEName != "" && !contains(MemoryPENames, s.PEName) {
+		return syscall.ENOENT
+	}
+	return nil
+}
+
+// serviceKey identifies a service the same way the kernel does, by
+// family and either firewall mark or protocol, address and port
+func serviceKey(s *Service) string {
+	if s.FWMark != 0 {
+		return fmt.Sprintf("%d/fwmark:%d", s.AddressFamily, s.FWMark)
+	}
+	return fmt.Sprintf("%d/%d/%s/%d", s.AddressFamily, s.Protocol, s.Address, s.Port)
+}
+
+// destinationKey identifies a destination within a service by family, address and port
+func destinationKey(d *Destination) string {
+	return fmt.Sprintf("%d/%s/%d", d.AddressFamily, d.Address, d.Port)
+}
+
+func isValidFamilyAddress(family uint16, ip net.IP) bool {
+	switch family {
+	case syscall.AF_INET:
+		return ip.To4() != nil
+	case syscall.AF_INET6:
+		return ip.To16() != nil
+	}
+	return false
+}
+
+func copyIP(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	return append(net.IP(nil), ip...)
+}
+
+func copyService(s *Service) Service {
+	res := *s
+	res.Address = copyIP(s.Address)
+	return res
+}
+
+func copyDestination(d *Destination) Destination {
+	res := *d
+	res.Address = copyIP(d.Address)
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
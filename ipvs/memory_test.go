@@ -0,0 +1,223 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestMemoryServices(t *testing.T) {
+	m := NewMemory()
+
+	s := &Service{
+		AddressFamily: syscall.AF_INET,
+		Protocol:      syscall.IPPROTO_TCP,
+		Address:       net.ParseIP("10.0.0.1"),
+		Port:          80,
+		SchedName:     "rr",
+		Netmask:       0xFFFFFFFF,
+	}
+
+	if err := m.NewService(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewService(s); err != syscall.EEXIST {
+		t.Errorf("expected EEXIST for duplicate service, got %v", err)
+	}
+
+	unknown := *s
+	unknown.Port = 81
+	if err := m.UpdateService(&unknown); err != syscall.ESRCH {
+		t.Errorf("expected ESRCH for update of missing service, got %v", err)
+	}
+	if err := m.DelService(&unknown); err != syscall.ESRCH {
+		t.Errorf("expected ESRCH for deletion of missing service, got %v", err)
+	}
+	unknown.SchedName = "nosuchsched"
+	if err := m.NewService(&unknown); err != syscall.ENOENT {
+		t.Errorf("expected ENOENT for unknown scheduler, got %v", err)
+	}
+
+	s.SchedName = "wlc"
+	if err := m.UpdateService(s); err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.GetService(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SchedName != "wlc" || res.Flags&SvcFlagHashed == 0 {
+		t.Errorf("unexpected service %#v", res)
+	}
+
+	if err := m.DelService(s); err != nil {
+		t.Fatal(err)
+	}
+	if m.IsServicePresent(s) {
+		t.Error("service must not be present after deletion")
+	}
+}
+
+func TestMemoryDestinations(t *testing.T) {
+	m := NewMemory()
+
+	s := &Service{
+		AddressFamily: syscall.AF_INET,
+		Protocol:      syscall.IPPROTO_TCP,
+		Address:       net.ParseIP("10.0.0.1"),
+		Port:          80,
+		SchedName:     "rr",
+	}
+	d := &Destination{
+		Address: net.ParseIP("10.1.0.1"),
+		Port:    8080,
+		Weight:  100,
+	}
+
+	if err := m.NewDestination(s, d); err != syscall.ESRCH {
+		t.Errorf("expected ESRCH for missing service, got %v", err)
+	}
+	if err := m.NewService(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewDestination(s, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewDestination(s, d); err != syscall.EEXIST {
+		t.Errorf("expected EEXIST for duplicate destination, got %v", err)
+	}
+
+	other := &Destination{Address: net.ParseIP("10.1.0.2"), Port: 8080}
+	if err := m.UpdateDestination(s, other); err != syscall.ENOENT {
+		t.Errorf("expected ENOENT for update of missing destination, got %v", err)
+	}
+	if err := m.DelDestination(s, other); err != syscall.ENOENT {
+		t.Errorf("expected ENOENT for deletion of missing destination, got %v", err)
+	}
+
+	other.LowerThreshold = 10
+	if err := m.NewDestination(s, other); err != syscall.ERANGE {
+		t.Errorf("expected ERANGE for lower threshold above upper, got %v", err)
+	}
+
+	mixed := &Destination{Address: net.ParseIP("fd00::1"), Port: 8080, AddressFamily: syscall.AF_INET6}
+	if err := m.NewDestination(s, mixed); err != syscall.EINVAL {
+		t.Errorf("expected EINVAL for mixed family without tunnel, got %v", err)
+	}
+	mixed.ConnectionFlags = ConnectionFlagTunnel
+	if err := m.NewDestination(s, mixed); err != nil {
+		t.Errorf("expected mixed family with tunnel to succeed, got %v", err)
+	}
+
+	d.Weight = 0
+	if err := m.UpdateDestination(s, d); err != nil {
+		t.Fatal(err)
+	}
+	dests, err := m.GetDestinations(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dests) != 2 || dests[0].Weight != 0 || dests[0].AddressFamily != syscall.AF_INET {
+		t.Errorf("unexpected destinations %#v", dests)
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	m := NewMemory()
+
+	s := &Service{AddressFamily: syscall.AF_INET, FWMark: 1, SchedName: "rr"}
+	d := &Destination{Address: net.ParseIP("10.1.0.1")}
+
+	if err := m.NewService(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewDestination(s, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetStats(s, nil, SvcStats{Connections: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetStats(s, d, SvcStats{Connections: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, _ := m.GetService(s)
+	if res.Stats.Connections != 5 {
+		t.Errorf("expected 5 connections, got %d", res.Stats.Connections)
+	}
+
+	if err := m.Zero(nil); err != nil {
+		t.Fatal(err)
+	}
+	res, _ = m.GetService(s)
+	dests, _ := m.GetDestinations(s)
+	if res.Stats.Connections != 0 || dests[0].Stats.Connections != 0 {
+		t.Error("expected zeroed counters")
+	}
+}
+
+func TestMemoryDaemonsAndConfig(t *testing.T) {
+	m := NewMemory()
+
+	d := &Daemon{State: DaemonStateMaster, McastIfn: "eth0", SyncID: 1}
+	if err := m.NewDaemon(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewDaemon(d); err != syscall.EEXIST {
+		t.Errorf("expected EEXIST for running daemon, got %v", err)
+	}
+	daemons, _ := m.GetDaemons()
+	if len(daemons) != 1 || daemons[0].McastPort != MemoryDefaultMcastPort || !daemons[0].McastGroup.Equal(MemoryDefaultMcastGroup) {
+		t.Errorf("unexpected daemons %#v", daemons)
+	}
+	if err := m.DelDaemon(&Daemon{State: DaemonStateBackup}); err != syscall.ESRCH {
+		t.Errorf("expected ESRCH for stopped daemon, got %v", err)
+	}
+
+	if err := m.SetConfig(&Config{TimeoutUDP: MemoryDefaultTimeoutUDP * 2}); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := m.GetConfig()
+	if c.TimeoutTCP != MemoryDefaultTimeoutTCP || c.TimeoutUDP != MemoryDefaultTimeoutUDP*2 {
+		t.Errorf("unexpected config %#v", c)
+	}
+}
+
+func TestMemoryContext(t *testing.T) {
+	m := NewMemory()
+
+	s := &Service{
+		AddressFamily: syscall.AF_INET,
+		Protocol:      syscall.IPPROTO_TCP,
+		Address:       net.ParseIP("10.0.0.1"),
+		Port:          80,
+		SchedName:     "rr",
+		Netmask:       0xFFFFFFFF,
+	}
+
+	if err := m.NewServiceContext(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var timeoutErr *TimeoutError
+	if err := m.DelServiceContext(ctx, s); !errors.As(err, &timeoutErr) {
+		t.Errorf("expected TimeoutError for cancelled context, got %v", err)
+	}
+	if _, err := m.GetServicesContext(ctx); !errors.As(err, &timeoutErr) {
+		t.Errorf("expected TimeoutError for cancelled context, got %v", err)
+	}
+	if m.IsServicePresentContext(ctx, s) {
+		t.Error("expected service not to be reported for cancelled context")
+	}
+	if !m.IsServicePresent(s) {
+		t.Error("service must not be deleted by a cancelled operation")
+	}
+}
@@ -0,0 +1,145 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SysModuleDir contains a directory for every loaded (or built-in) kernel module
+	SysModuleDir = "/sys/module"
+
+	// KernelModulesDir contains the modules of all installed kernels
+	KernelModulesDir = "/lib/modules"
+)
+
+// nonSchedulerModules are ip_vs_* modules which do not provide schedulers
+var nonSchedulerModules = []string{"ftp"}
+
+// AvailableSchedulers returns the names of all schedulers provided by the
+// running kernel, i.e. ip_vs_<name> modules which are loaded, built in or
+// loadable. It returns an error if none of these can be determined, e.g.
+// within containers lacking /lib/modules.
+func AvailableSchedulers() ([]string, error) {
+	modules, err := kernelIPVSModules()
+	if err != nil {
+		return nil, err
+	}
+	return schedulersOf(modules), nil
+}
+
+// AvailablePENames returns the names of all persistence engines provided by
+// the running kernel, i.e. ip_vs_pe_<name> modules.
+func AvailablePENames() ([]string, error) {
+	modules, err := kernelIPVSModules()
+	if err != nil {
+		return nil, err
+	}
+	return peNamesOf(modules), nil
+}
+
+func kernelIPVSModules() ([]string, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return nil, err
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	return ipvsModules(SysModuleDir, filepath.Join(KernelModulesDir, release))
+}
+
+// ipvsModules returns the names of all ip_vs_* modules without prefix, which
+// are loaded, built in or loadable. Only the module index of depmod is
+// complete, so it returns an error if neither modules.builtin nor
+// modules.dep can be read. Loaded modules only complement the index.
+func ipvsModules(sysModuleDir, modulesDir string) ([]string, error) {
+	found := make(map[string]bool)
+	indexed := false
+
+	// built-in and loadable modules, as listed by depmod
+	for _, name := range []string{"modules.builtin", "modules.dep"} {
+		fileName := filepath.Join(modulesDir, name)
+		f, err := os.Open(fileName)
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// e.g. kernel/net/netfilter/ipvs/ip_vs_rr.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+			path := strings.SplitN(scanner.Text(), ":", 2)[0]
+			module := filepath.Base(path)
+			if i := strings.Index(module, ".ko"); i != -1 {
+				module = module[:i]
+			}
+			addIPVSModule(found, module)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", fileName, err)
+		}
+		indexed = true
+	}
+
+	if !indexed {
+		return nil, errors.New("unable to determine kernel modules, no module index in " + modulesDir)
+	}
+
+	// loaded modules, e.g. built from outside the kernel tree
+	if entries, err := os.ReadDir(sysModuleDir); err == nil {
+		for _, e := range entries {
+			addIPVSModule(found, e.Name())
+		}
+	}
+
+	res := make([]string, 0, len(found))
+	for name := range found {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res, nil
+}
+
+// addIPVSModule adds module to found without its ip_vs_ prefix, if it is an ipvs module
+func addIPVSModule(found map[string]bool, module string) {
+	module = strings.ReplaceAll(module, "-", "_")
+	if !strings.HasPrefix(module, "ip_vs_") {
+		return
+	}
+	found[strings.TrimPrefix(module, "ip_vs_")] = true
+}
+
+// schedulersOf filters scheduler names from ipvs module names
+func schedulersOf(modules []string) []string {
+	res := []string{}
+	for _, name := range modules {
+		if strings.HasPrefix(name, "pe_") || contains(nonSchedulerModules, name) {
+			continue
+		}
+		res = append(res, name)
+	}
+	return res
+}
+
+// peNamesOf filters persistence engine names from ipvs module names
+func peNamesOf(modules []string) []string {
+	res := []string{}
+	for _, name := range modules {
+		if strings.HasPrefix(name, "pe_") {
+			res = append(res, strings.TrimPrefix(name, "pe_"))
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIPVSModules(t *testing.T) {
+	sysModuleDir := t.TempDir()
+	for _, m := range []string{"ip_vs", "ip_vs_rr", "ip_vs_ftp", "nf_conntrack"} {
+		if err := os.Mkdir(filepath.Join(sysModuleDir, m), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modulesDir := t.TempDir()
+	dep := `kernel/net/netfilter/ipvs/ip_vs.ko.zst: kernel/net/netfilter/nf_conntrack.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_mh.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_pe_sip.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_twos.ko: kernel/net/netfilter/ipvs/ip_vs.ko
+`
+	if err := os.WriteFile(filepath.Join(modulesDir, "modules.dep"), []byte(dep), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modulesDir, "modules.builtin"), []byte("kernel/net/netfilter/ipvs/ip_vs_wlc.ko\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	modules, err := ipvsModules(sysModuleDir, modulesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"mh", "rr", "twos", "wlc"}
+	if res := schedulersOf(modules); !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v, got %v", expected, res)
+	}
+	expected = []string{"sip"}
+	if res := peNamesOf(modules); !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v, got %v", expected, res)
+	}
+
+	// loaded modules alone are incomplete, e.g. within containers
+	_, err = ipvsModules(sysModuleDir, filepath.Join(modulesDir, "nonexisting"))
+	if err == nil {
+		t.Error("expected an error without module index")
+	}
+
+	_, err = ipvsModules(filepath.Join(sysModuleDir, "nonexisting"), filepath.Join(modulesDir, "nonexisting"))
+	if err == nil {
+		t.Error("expected an error without any module sources")
+	}
+}
@@ -8,13 +8,10 @@ package ipvs
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"net"
-	"os"
-	"os/exec"
-	"strings"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -22,13 +19,13 @@ import (
 
 	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 // For Quick Reference IPVS related netlink message is described at the end of this file.
 var (
 	native     = nl.NativeEndian()
 	ipvsFamily int
-	ipvsOnce   sync.Once
 )
 
 type genlMsgHdr struct {
@@ -62,23 +59,6 @@ func (f *ipvsFlags) Len() int {
 	return int(unsafe.Sizeof(*f))
 }
 
-func setup() {
-	ipvsOnce.Do(func() {
-		var err error
-		if out, err := exec.Command("modprobe", "-va", "ip_vs").CombinedOutput(); err != nil {
-			if os.Getenv("SKIP_IPVSKERNELREQ") != "1" {
-				fmt.Fprintf(os.Stderr, "Running modprobe ip_vs failed with message: `%s`, error: %v", strings.TrimSpace(string(out)), err)
-				os.Exit(1)
-			}
-		}
-
-		ipvsFamily, err = getIPVSFamily()
-		if err != nil {
-			// ("Could not get ipvs family information from the kernel. It is possible that ipvs is not enabled in your kernel. Native loadbalancing will not work until this is fixed.")
-		}
-	})
-}
-
 func fillService(s *Service) nl.NetlinkRequestData {
 	cmdAttr := nl.NewRtAttr(ipvsCmdAttrService, nil)
 	nl.NewRtAttrChild(cmdAttr, ipvsSvcAttrAddressFamily, nl.Uint16Attr(s.AddressFamily))
@@ -124,11 +104,23 @@ func fillDestination(d *Destination) nl.NetlinkRequestData {
 	nl.NewRtAttrChild(cmdAttr, ipvsDestAttrWeight, nl.Uint32Attr(uint32(d.Weight)))
 	nl.NewRtAttrChild(cmdAttr, ipvsDestAttrUpperThreshold, nl.Uint32Attr(d.UpperThreshold))
 	nl.NewRtAttrChild(cmdAttr, ipvsDestAttrLowerThreshold, nl.Uint32Attr(d.LowerThreshold))
+	if d.AddressFamily != 0 {
+		nl.NewRtAttrChild(cmdAttr, ipvsDestAttrAddressFamily, nl.Uint16Attr(d.AddressFamily))
+	}
+
+	// tunnel attributes are only known to newer kernels, so only send them when needed
+	if d.TunnelType != TunnelTypeIPIP || d.TunnelPort != 0 || d.TunnelFlags != 0 {
+		nl.NewRtAttrChild(cmdAttr, ipvsDestAttrTunType, nl.Uint8Attr(d.TunnelType))
+		tunPortBuf := new(bytes.Buffer)
+		binary.Write(tunPortBuf, binary.BigEndian, d.TunnelPort)
+		nl.NewRtAttrChild(cmdAttr, ipvsDestAttrTunPort, tunPortBuf.Bytes())
+		nl.NewRtAttrChild(cmdAttr, ipvsDestAttrTunFlags, nl.Uint16Attr(d.TunnelFlags))
+	}
 
 	return cmdAttr
 }
 
-func (i *Handle) doCmdwithResponse(s *Service, d *Destination, cmd uint8) ([][]byte, error) {
+func (i *Handle) doCmdwithResponse(ctx context.Context, s *Service, d *Destination, cmd uint8) ([][]byte, error) {
 	req := newIPVSRequest(cmd)
 	req.Seq = atomic.AddUint32(&i.seq, 1)
 
@@ -150,7 +142,7 @@ func (i *Handle) doCmdwithResponse(s *Service, d *Destination, cmd uint8) ([][]b
 
 	//fmt.Printf("req=%#v\n", req)
 
-	res, err := execute(i.sock, req, 0)
+	res, err := i.execute(ctx, req, 0)
 	if err != nil {
 		return [][]byte{}, err
 	}
@@ -159,8 +151,8 @@ func (i *Handle) doCmdwithResponse(s *Service, d *Destination, cmd uint8) ([][]b
 	return res, nil
 }
 
-func (i *Handle) doCmd(s *Service, d *Destination, cmd uint8) error {
-	_, err := i.doCmdwithResponse(s, d, cmd)
+func (i *Handle) doCmd(ctx context.Context, s *Service, d *Destination, cmd uint8) error {
+	_, err := i.doCmdwithResponse(ctx, s, d, cmd)
 
 	return err
 }
@@ -175,7 +167,7 @@ func getIPVSFamily() (int, error) {
 	req := newGenlRequest(genlCtrlID, genlCtrlCmdGetFamily)
 	req.AddData(nl.NewRtAttr(genlCtrlAttrFamilyName, nl.ZeroTerminated("IPVS")))
 
-	msgs, err := execute(sock, req, 0)
+	msgs, err := execute(context.Background(), sock, req, 0)
 	if err != nil {
 		return 0, err
 	}
@@ -216,7 +208,26 @@ func newGenlRequest(familyID int, cmd uint8) *nl.NetlinkRequest {
 	return req
 }
 
-func execute(s *nl.NetlinkSocket, req *nl.NetlinkRequest, resType uint16) ([][]byte, error) {
+// execute sends req on the handle's socket and receives the response.
+// Requests are serialized, so a Handle is safe for concurrent use.
+func (i *Handle) execute(ctx context.Context, req *nl.NetlinkRequest, resType uint16) ([][]byte, error) {
+	select {
+	case i.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, &TimeoutError{Err: ctx.Err()}
+	}
+	defer func() { <-i.lock }()
+
+	return execute(ctx, i.sock, req, resType)
+}
+
+// execute sends req and receives the response until ctx is done. Late
+// responses to abandoned requests are skipped by their sequence number.
+func execute(ctx context.Context, s *nl.NetlinkSocket, req *nl.NetlinkRequest, resType uint16) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &TimeoutError{Err: err}
+	}
+
 	if err := s.Send(req); err != nil {
 		return nil, err
 	}
@@ -230,13 +241,16 @@ func execute(s *nl.NetlinkSocket, req *nl.NetlinkRequest, resType uint16) ([][]b
 
 done:
 	for {
+		if err := waitReadable(ctx, s.GetFd()); err != nil {
+			return nil, err
+		}
 		msgs, _, err := s.Receive()
 		if err != nil {
 			if s.GetFd() == -1 {
 				return nil, fmt.Errorf("socket got closed on receive")
 			}
 			if err == syscall.EAGAIN {
-				// timeout fired
+				// receive timeout fired, ctx is checked by waitReadable
 				continue
 			}
 			return nil, err
@@ -270,6 +284,42 @@ done:
 	return res, nil
 }
 
+// waitReadable blocks until fd is readable or ctx is done. It polls in
+// intervals of pollInterval to notice a cancellation without a deadline.
+func waitReadable(ctx context.Context, fd int) error {
+	if fd < 0 {
+		return fmt.Errorf("socket got closed on receive")
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if err := ctx.Err(); err != nil {
+			return &TimeoutError{Err: err}
+		}
+
+		timeout := pollInterval
+		if deadline, ok := ctx.Deadline(); ok {
+			if d := time.Until(deadline); d < timeout {
+				timeout = d
+			}
+		}
+		if timeout < time.Millisecond {
+			// do not poll with a timeout of 0, which would not block at all
+			timeout = time.Millisecond
+		}
+
+		n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+}
+
 func parseIP(ip []byte, family uint16) (net.IP, error) {
 
 	var resIP net.IP
@@ -286,6 +336,15 @@ func parseIP(ip []byte, family uint16) (net.IP, error) {
 	return resIP, nil
 }
 
+// statsValue reads a statistics counter. The 64-bit statistics carry all
+// values as u64, the 32-bit ones use u32 for everything but byte counters.
+func statsValue(b []byte) uint64 {
+	if len(b) >= 8 {
+		return native.Uint64(b)
+	}
+	return uint64(native.Uint32(b))
+}
+
 // parseStats
 func assembleStats(msg []byte) (SvcStats, error) {
 
@@ -300,25 +359,25 @@ func assembleStats(msg []byte) (SvcStats, error) {
 		attrType := int(attr.Attr.Type)
 		switch attrType {
 		case ipvsSvcStatsConns:
-			s.Connections = native.Uint32(attr.Value)
+			s.Connections = statsValue(attr.Value)
 		case ipvsSvcStatsPktsIn:
-			s.PacketsIn = native.Uint32(attr.Value)
+			s.PacketsIn = statsValue(attr.Value)
 		case ipvsSvcStatsPktsOut:
-			s.PacketsOut = native.Uint32(attr.Value)
+			s.PacketsOut = statsValue(attr.Value)
 		case ipvsSvcStatsBytesIn:
-			s.BytesIn = native.Uint64(attr.Value)
+			s.BytesIn = statsValue(attr.Value)
 		case ipvsSvcStatsBytesOut:
-			s.BytesOut = native.Uint64(attr.Value)
+			s.BytesOut = statsValue(attr.Value)
 		case ipvsSvcStatsCPS:
-			s.CPS = native.Uint32(attr.Value)
+			s.CPS = statsValue(attr.Value)
 		case ipvsSvcStatsPPSIn:
-			s.PPSIn = native.Uint32(attr.Value)
+			s.PPSIn = statsValue(attr.Value)
 		case ipvsSvcStatsPPSOut:
-			s.PPSOut = native.Uint32(attr.Value)
+			s.PPSOut = statsValue(attr.Value)
 		case ipvsSvcStatsBPSIn:
-			s.BPSIn = native.Uint32(attr.Value)
+			s.BPSIn = statsValue(attr.Value)
 		case ipvsSvcStatsBPSOut:
-			s.BPSOut = native.Uint32(attr.Value)
+			s.BPSOut = statsValue(attr.Value)
 		}
 	}
 	return s, nil
@@ -329,6 +388,7 @@ func assembleService(attrs []syscall.NetlinkRouteAttr) (*Service, error) {
 
 	var s Service
 	var addressBytes []byte
+	var hasStats64 bool
 
 	for _, attr := range attrs {
 
@@ -354,12 +414,24 @@ func assembleService(attrs []syscall.NetlinkRouteAttr) (*Service, error) {
 			s.Timeout = native.Uint32(attr.Value)
 		case ipvsSvcAttrNetmask:
 			s.Netmask = native.Uint32(attr.Value)
+		case ipvsSvcAttrPEName:
+			s.PEName = nl.BytesToString(attr.Value)
 		case ipvsSvcAttrStats:
+			if hasStats64 {
+				continue
+			}
 			stats, err := assembleStats(attr.Value)
 			if err != nil {
 				return nil, err
 			}
 			s.Stats = stats
+		case ipvsSvcAttrStats64:
+			stats, err := assembleStats(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			s.Stats = stats
+			hasStats64 = true
 		}
 
 	}
@@ -407,10 +479,10 @@ func (i *Handle) parseService(msg []byte) (*Service, error) {
 }
 
 // doGetServicesCmd a wrapper which could be used commonly for both GetServices() and GetService(*Service)
-func (i *Handle) doGetServicesCmd(svc *Service) ([]*Service, error) {
+func (i *Handle) doGetServicesCmd(ctx context.Context, svc *Service) ([]*Service, error) {
 	var res []*Service
 
-	msgs, err := i.doCmdwithResponse(svc, nil, ipvsCmdGetService)
+	msgs, err := i.doCmdwithResponse(ctx, svc, nil, ipvsCmdGetService)
 	if err != nil {
 		return nil, err
 	}
@@ -427,16 +499,20 @@ func (i *Handle) doGetServicesCmd(svc *Service) ([]*Service, error) {
 }
 
 // doCmdWithoutAttr a simple wrapper of netlink socket execute command
-func (i *Handle) doCmdWithoutAttr(cmd uint8) ([][]byte, error) {
+func (i *Handle) doCmdWithoutAttr(ctx context.Context, cmd uint8) ([][]byte, error) {
 	req := newIPVSRequest(cmd)
 	req.Seq = atomic.AddUint32(&i.seq, 1)
-	return execute(i.sock, req, 0)
+	return i.execute(ctx, req, 0)
 }
 
-func assembleDestination(attrs []syscall.NetlinkRouteAttr) (*Destination, error) {
+// assembleDestination assembles a destination from a chain of netlink attributes. Kernels
+// without mixed address family support do not report the destination's family, in this case
+// defaultFamily (the service's family) is used.
+func assembleDestination(attrs []syscall.NetlinkRouteAttr, defaultFamily uint16) (*Destination, error) {
 
 	var d Destination
 	var addressBytes []byte
+	var hasStats64 bool
 
 	for _, attr := range attrs {
 
@@ -462,15 +538,35 @@ func assembleDestination(attrs []syscall.NetlinkRouteAttr) (*Destination, error)
 			d.ActiveConnections = int(native.Uint16(attr.Value))
 		case ipvsDestAttrInactiveConnections:
 			d.InactiveConnections = int(native.Uint16(attr.Value))
-		case ipvsSvcAttrStats:
+		case ipvsDestAttrTunType:
+			d.TunnelType = attr.Value[0]
+		case ipvsDestAttrTunPort:
+			d.TunnelPort = binary.BigEndian.Uint16(attr.Value)
+		case ipvsDestAttrTunFlags:
+			d.TunnelFlags = native.Uint16(attr.Value)
+		case ipvsDestAttrStats:
+			if hasStats64 {
+				continue
+			}
+			stats, err := assembleStats(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			d.Stats = DstStats(stats)
+		case ipvsDestAttrStats64:
 			stats, err := assembleStats(attr.Value)
 			if err != nil {
 				return nil, err
 			}
 			d.Stats = DstStats(stats)
+			hasStats64 = true
 		}
 	}
 
+	if d.AddressFamily == 0 {
+		d.AddressFamily = defaultFamily
+	}
+
 	// parse Address after parse AddressFamily incase of parseIP error
 	if addressBytes != nil {
 		ip, err := parseIP(addressBytes, d.AddressFamily)
@@ -484,7 +580,7 @@ func assembleDestination(attrs []syscall.NetlinkRouteAttr) (*Destination, error)
 }
 
 // parseDestination given a ipvs netlink response this function will respond with a valid destination entry, an error otherwise
-func (i *Handle) parseDestination(msg []byte) (*Destination, error) {
+func (i *Handle) parseDestination(msg []byte, defaultFamily uint16) (*Destination, error) {
 	var dst *Destination
 
 	//Remove General header for this message
@@ -504,7 +600,7 @@ func (i *Handle) parseDestination(msg []byte) (*Destination, error) {
 	}
 
 	//Assemble netlink attributes and create a Destination record
-	dst, err = assembleDestination(ipvsAttrs)
+	dst, err = assembleDestination(ipvsAttrs, defaultFamily)
 	if err != nil {
 		return nil, err
 	}
@@ -513,17 +609,22 @@ func (i *Handle) parseDestination(msg []byte) (*Destination, error) {
 }
 
 // doGetDestinationsCmd a wrapper function to be used by GetDestinations and GetDestination(d) apis
-func (i *Handle) doGetDestinationsCmd(s *Service, d *Destination) ([]*Destination, error) {
+func (i *Handle) doGetDestinationsCmd(ctx context.Context, s *Service, d *Destination) ([]*Destination, error) {
 
 	var res []*Destination
 
-	msgs, err := i.doCmdwithResponse(s, d, ipvsCmdGetDest)
+	msgs, err := i.doCmdwithResponse(ctx, s, d, ipvsCmdGetDest)
 	if err != nil {
 		return nil, err
 	}
 
+	var defaultFamily uint16
+	if s != nil {
+		defaultFamily = s.AddressFamily
+	}
+
 	for _, msg := range msgs {
-		dest, err := i.parseDestination(msg)
+		dest, err := i.parseDestination(msg, defaultFamily)
 		if err != nil {
 			return res, err
 		}
@@ -559,11 +660,14 @@ func (i *Handle) parseConfig(msg []byte) (*Config, error) {
 }
 
 // doGetConfigCmd a wrapper function to be used by GetConfig
-func (i *Handle) doGetConfigCmd() (*Config, error) {
-	msg, err := i.doCmdWithoutAttr(ipvsCmdGetConfig)
+func (i *Handle) doGetConfigCmd(ctx context.Context) (*Config, error) {
+	msg, err := i.doCmdWithoutAttr(ctx, ipvsCmdGetConfig)
 	if err != nil {
 		return nil, err
 	}
+	if len(msg) == 0 {
+		return nil, fmt.Errorf("no config received")
+	}
 
 	res, err := i.parseConfig(msg[0])
 	if err != nil {
@@ -573,7 +677,7 @@ func (i *Handle) doGetConfigCmd() (*Config, error) {
 }
 
 // doSetConfigCmd a wrapper function to be used by SetConfig
-func (i *Handle) doSetConfigCmd(c *Config) error {
+func (i *Handle) doSetConfigCmd(ctx context.Context, c *Config) error {
 	req := newIPVSRequest(ipvsCmdSetConfig)
 	req.Seq = atomic.AddUint32(&i.seq, 1)
 
@@ -581,11 +685,123 @@ func (i *Handle) doSetConfigCmd(c *Config) error {
 	req.AddData(nl.NewRtAttr(ipvsCmdAttrTimeoutTCPFin, nl.Uint32Attr(uint32(c.TimeoutTCPFin.Seconds()))))
 	req.AddData(nl.NewRtAttr(ipvsCmdAttrTimeoutUDP, nl.Uint32Attr(uint32(c.TimeoutUDP.Seconds()))))
 
-	_, err := execute(i.sock, req, 0)
+	_, err := i.execute(ctx, req, 0)
 
 	return err
 }
 
+func fillDaemon(d *Daemon) nl.NetlinkRequestData {
+	cmdAttr := nl.NewRtAttr(ipvsCmdAttrDaemon, nil)
+
+	nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrState, nl.Uint32Attr(d.State))
+	if d.McastIfn != "" {
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastIfn, nl.ZeroTerminated(d.McastIfn))
+	}
+	nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrSyncID, nl.Uint32Attr(d.SyncID))
+	if d.SyncMaxLen != 0 {
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrSyncMaxLen, nl.Uint16Attr(d.SyncMaxLen))
+	}
+	if d.McastGroup != nil {
+		if ip4 := d.McastGroup.To4(); ip4 != nil {
+			nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastGroup, []byte(ip4))
+		} else {
+			nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastGroup6, []byte(d.McastGroup.To16()))
+		}
+	}
+	if d.McastPort != 0 {
+		// Other than service and destination ports, the kernel expects
+		// the multicast port in host byte order.
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastPort, nl.Uint16Attr(d.McastPort))
+	}
+	if d.McastTTL != 0 {
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastTTL, nl.Uint8Attr(d.McastTTL))
+	}
+
+	return cmdAttr
+}
+
+// doDaemonCmd sends a daemon related command. If d is nil, a dump is requested.
+func (i *Handle) doDaemonCmd(ctx context.Context, d *Daemon, cmd uint8) ([][]byte, error) {
+	req := newIPVSRequest(cmd)
+	req.Seq = atomic.AddUint32(&i.seq, 1)
+
+	if d == nil {
+		req.Flags |= syscall.NLM_F_DUMP
+	} else {
+		req.AddData(fillDaemon(d))
+	}
+
+	return i.execute(ctx, req, 0)
+}
+
+func assembleDaemon(attrs []syscall.NetlinkRouteAttr) (*Daemon, error) {
+	var d Daemon
+
+	for _, attr := range attrs {
+		attrType := int(attr.Attr.Type)
+		switch attrType {
+		case ipvsDaemonAttrState:
+			d.State = native.Uint32(attr.Value)
+		case ipvsDaemonAttrMcastIfn:
+			d.McastIfn = nl.BytesToString(attr.Value)
+		case ipvsDaemonAttrSyncID:
+			d.SyncID = native.Uint32(attr.Value)
+		case ipvsDaemonAttrSyncMaxLen:
+			d.SyncMaxLen = native.Uint16(attr.Value)
+		case ipvsDaemonAttrMcastGroup:
+			d.McastGroup = net.IP(attr.Value[:4])
+		case ipvsDaemonAttrMcastGroup6:
+			d.McastGroup = net.IP(attr.Value[:16])
+		case ipvsDaemonAttrMcastPort:
+			d.McastPort = native.Uint16(attr.Value)
+		case ipvsDaemonAttrMcastTTL:
+			d.McastTTL = attr.Value[0]
+		}
+	}
+
+	return &d, nil
+}
+
+// parseDaemon given a ipvs netlink response this function will respond with a valid daemon entry, an error otherwise
+func (i *Handle) parseDaemon(msg []byte) (*Daemon, error) {
+	//Remove General header for this message
+	hdr := deserializeGenlMsg(msg)
+	NetLinkAttrs, err := nl.ParseRouteAttr(msg[hdr.Len():])
+	if err != nil {
+		return nil, err
+	}
+	if len(NetLinkAttrs) == 0 {
+		return nil, fmt.Errorf("error no valid netlink message found while parsing daemon record")
+	}
+
+	//Now Parse and get IPVS related attributes messages packed in this message.
+	ipvsAttrs, err := nl.ParseRouteAttr(NetLinkAttrs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleDaemon(ipvsAttrs)
+}
+
+// doGetDaemonsCmd a wrapper function to be used by GetDaemons
+func (i *Handle) doGetDaemonsCmd(ctx context.Context) ([]*Daemon, error) {
+	var res []*Daemon
+
+	msgs, err := i.doDaemonCmd(ctx, nil, ipvsCmdGetDaemon)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		d, err := i.parseDaemon(msg)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
 // IPVS related netlink message format explained
 
 /* EACH NETLINK MSG is of the below format, this is what we will receive from execute() api.
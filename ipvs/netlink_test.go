@@ -0,0 +1,231 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+func TestAssembleServiceStats64(t *testing.T) {
+	stats := nl.NewRtAttr(ipvsSvcAttrStats, nil)
+	nl.NewRtAttrChild(stats, ipvsSvcStatsConns, nl.Uint32Attr(1))
+	nl.NewRtAttrChild(stats, ipvsSvcStatsBytesIn, nl.Uint64Attr(2))
+
+	stats64 := nl.NewRtAttr(ipvsSvcAttrStats64, nil)
+	nl.NewRtAttrChild(stats64, ipvsSvcStatsConns, nl.Uint64Attr(1<<40))
+	nl.NewRtAttrChild(stats64, ipvsSvcStatsBytesIn, nl.Uint64Attr(1<<50))
+
+	for _, order := range [][]*nl.RtAttr{{stats, stats64}, {stats64, stats}} {
+		var b []byte
+		for _, a := range order {
+			b = append(b, a.Serialize()...)
+		}
+		attrs, err := nl.ParseRouteAttr(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := assembleService(attrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Stats.Connections != 1<<40 {
+			t.Errorf("Connections was incorrect: %d", s.Stats.Connections)
+		}
+		if s.Stats.BytesIn != 1<<50 {
+			t.Errorf("BytesIn was incorrect: %d", s.Stats.BytesIn)
+		}
+	}
+}
+
+func TestAssembleDestinationStats32(t *testing.T) {
+	stats := nl.NewRtAttr(ipvsDestAttrStats, nil)
+	nl.NewRtAttrChild(stats, ipvsSvcStatsConns, nl.Uint32Attr(42))
+	nl.NewRtAttrChild(stats, ipvsSvcStatsPktsOut, nl.Uint32Attr(0xffffffff))
+	nl.NewRtAttrChild(stats, ipvsSvcStatsBytesOut, nl.Uint64Attr(1<<33))
+
+	attrs, err := nl.ParseRouteAttr(stats.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := assembleDestination(attrs, syscall.AF_INET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Stats.Connections != 42 {
+		t.Errorf("Connections was incorrect: %d", d.Stats.Connections)
+	}
+	if d.Stats.PacketsOut != 0xffffffff {
+		t.Errorf("PacketsOut was incorrect: %d", d.Stats.PacketsOut)
+	}
+	if d.Stats.BytesOut != 1<<33 {
+		t.Errorf("BytesOut was incorrect: %d", d.Stats.BytesOut)
+	}
+}
+
+func TestAssembleServicePEName(t *testing.T) {
+	b := nl.NewRtAttr(ipvsSvcAttrSchedName, nl.ZeroTerminated("rr")).Serialize()
+	b = append(b, nl.NewRtAttr(ipvsSvcAttrPEName, nl.ZeroTerminated("sip")).Serialize()...)
+
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := assembleService(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SchedName != "rr" {
+		t.Errorf("SchedName was incorrect: %s", s.SchedName)
+	}
+	if s.PEName != "sip" {
+		t.Errorf("PEName was incorrect: %s", s.PEName)
+	}
+}
+
+func TestFillAssembleDestinationTunnel(t *testing.T) {
+	d := &Destination{
+		Address:         net.ParseIP("10.0.0.1"),
+		Port:            80,
+		ConnectionFlags: ConnectionFlagTunnel,
+		TunnelType:      TunnelTypeGUE,
+		TunnelPort:      6080,
+		TunnelFlags:     TunnelFlagRemCsum,
+	}
+
+	cmdAttr := fillDestination(d).(*nl.RtAttr)
+	attrs, err := nl.ParseRouteAttr(cmdAttr.Serialize()[syscall.SizeofRtAttr:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := assembleDestination(attrs, syscall.AF_INET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TunnelType != TunnelTypeGUE || res.TunnelPort != 6080 || res.TunnelFlags != TunnelFlagRemCsum {
+		t.Errorf("Tunnel was incorrect: %d, %d, %d", res.TunnelType, res.TunnelPort, res.TunnelFlags)
+	}
+	if !res.Address.Equal(d.Address) || res.Port != 80 {
+		t.Errorf("Address was incorrect: %s:%d", res.Address, res.Port)
+	}
+}
+
+func TestFillAssembleDestinationMixedFamily(t *testing.T) {
+	d := &Destination{
+		Address:         net.ParseIP("10.0.0.1"),
+		Port:            80,
+		ConnectionFlags: ConnectionFlagTunnel,
+		AddressFamily:   syscall.AF_INET,
+	}
+
+	cmdAttr := fillDestination(d).(*nl.RtAttr)
+	attrs, err := nl.ParseRouteAttr(cmdAttr.Serialize()[syscall.SizeofRtAttr:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// destination family wins over the family of an ipv6 service
+	res, err := assembleDestination(attrs, syscall.AF_INET6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.AddressFamily != syscall.AF_INET {
+		t.Errorf("AddressFamily was incorrect: %d", res.AddressFamily)
+	}
+	if !res.Address.Equal(d.Address) {
+		t.Errorf("Address was incorrect: %s", res.Address)
+	}
+}
+
+func TestFillAssembleDaemon(t *testing.T) {
+	d := &Daemon{
+		State:      DaemonStateMaster,
+		McastIfn:   "eth0",
+		SyncID:     7,
+		McastGroup: net.ParseIP("224.0.0.81"),
+		McastPort:  8848,
+		McastTTL:   1,
+	}
+
+	cmdAttr := fillDaemon(d).(*nl.RtAttr)
+	attrs, err := nl.ParseRouteAttr(cmdAttr.Serialize()[syscall.SizeofRtAttr:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the kernel reads the multicast port in host byte order
+	for _, attr := range attrs {
+		if int(attr.Attr.Type) == ipvsDaemonAttrMcastPort && native.Uint16(attr.Value) != 8848 {
+			t.Errorf("McastPort was not encoded in host byte order: %v", attr.Value)
+		}
+	}
+
+	res, err := assembleDaemon(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != d.State || res.McastIfn != "eth0" || res.SyncID != 7 || res.McastTTL != 1 {
+		t.Errorf("Daemon was incorrect: %#v", res)
+	}
+	if res.McastPort != 8848 {
+		t.Errorf("McastPort was incorrect: %d", res.McastPort)
+	}
+	if !res.McastGroup.Equal(d.McastGroup) {
+		t.Errorf("McastGroup was incorrect: %s", res.McastGroup)
+	}
+}
+
+func TestWaitReadable(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	fd := int(r.Fd())
+
+	// nothing to read, the deadline must fire
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = waitReadable(ctx, fd)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected TimeoutError for exceeded deadline, got %v", err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond+pollInterval {
+		t.Errorf("deadline not honoured, waited %s", d)
+	}
+
+	// cancellation without a deadline must be noticed
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = waitReadable(ctx, fd)
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected TimeoutError for cancellation, got %v", err)
+	}
+
+	// readable
+	if _, err := w.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitReadable(context.Background(), fd); err != nil {
+		t.Errorf("expected fd to be readable, got %v", err)
+	}
+
+	if err := waitReadable(context.Background(), -1); err == nil {
+		t.Error("expected error for closed socket")
+	}
+}
@@ -0,0 +1,181 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in capability sets
+const capNetAdmin = 12
+
+// Capabilities describes what the running kernel and process provide for
+// ipvs. Items which could not be determined are nil (or 0) and the reason
+// is listed in Errors.
+type Capabilities struct {
+	FamilyPresent bool              `yaml:"family-present" json:"family-present"`                       // IPVS generic netlink family is registered
+	Schedulers    []string          `yaml:"schedulers,omitempty" json:"schedulers,omitempty"`           // loaded, built-in or loadable schedulers
+	PENames       []string          `yaml:"pe-names,omitempty" json:"pe-names,omitempty"`               // loaded, built-in or loadable persistence engines
+	ConnTableSize int               `yaml:"conn-table-size,omitempty" json:"conn-table-size,omitempty"` // size of the ipvs connection hash table
+	ConntrackMax  int               `yaml:"conntrack-max,omitempty" json:"conntrack-max,omitempty"`     // maximum number of netfilter conntrack entries
+	Sysctls       map[string]string `yaml:"sysctls,omitempty" json:"sysctls,omitempty"`                 // settings in /proc/sys/net/ipv4/vs
+	NetAdmin      bool              `yaml:"net-admin" json:"net-admin"`                                 // process has CAP_NET_ADMIN
+	Errors        []string          `yaml:"errors,omitempty" json:"errors,omitempty"`                   // items which could not be probed
+}
+
+// prober contains all locations used by Probe, so they can be replaced in tests
+type prober struct {
+	sysModuleDir string
+	modulesDir   string
+	procDir      string
+	family       func() (int, error)
+}
+
+// Probe determines the ipvs capabilities of the running kernel and process.
+// It does not load any kernel module and does not fail, errors are reported
+// as part of Capabilities.
+func Probe() *Capabilities {
+	p := &prober{
+		sysModuleDir: SysModuleDir,
+		procDir:      "/proc",
+		family:       getIPVSFamily,
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		p.modulesDir = filepath.Join(KernelModulesDir, unix.ByteSliceToString(uts.Release[:]))
+	}
+
+	return p.probe()
+}
+
+func (p *prober) probe() *Capabilities {
+	c := &Capabilities{}
+	fail := func(what string, err error) {
+		c.Errors = append(c.Errors, fmt.Sprintf("%s: %s", what, err))
+	}
+
+	if _, err := p.family(); err == nil {
+		c.FamilyPresent = true
+	} else if !errors.Is(err, syscall.ENOENT) {
+		fail("netlink family", err)
+	}
+
+	if modules, err := ipvsModules(p.sysModuleDir, p.modulesDir); err == nil {
+		c.Schedulers = schedulersOf(modules)
+		c.PENames = peNamesOf(modules)
+	} else {
+		fail("kernel modules", err)
+	}
+
+	if v, err := readIntFile(filepath.Join(p.sysModuleDir, "ip_vs", "parameters", "conn_tab_size")); err == nil {
+		c.ConnTableSize = v
+	} else if c.FamilyPresent {
+		fail("connection table size", err)
+	}
+
+	if v, err := readIntFile(filepath.Join(p.procDir, "sys", "net", "netfilter", "nf_conntrack_max")); err == nil {
+		c.ConntrackMax = v
+	}
+
+	sysctlDir := filepath.Join(p.procDir, "sys", "net", "ipv4", "vs")
+	if entries, err := os.ReadDir(sysctlDir); err == nil {
+		c.Sysctls = make(map[string]string)
+		for _, e := range entries {
+			b, err := os.ReadFile(filepath.Join(sysctlDir, e.Name()))
+			if err != nil {
+				// some entries are write-only or need privileges
+				continue
+			}
+			c.Sysctls[e.Name()] = strings.Join(strings.Fields(string(b)), " ")
+		}
+	} else if c.FamilyPresent {
+		fail("sysctls", err)
+	}
+
+	if netAdmin, err := hasNetAdmin(filepath.Join(p.procDir, "self", "status")); err == nil {
+		c.NetAdmin = netAdmin
+	} else {
+		fail("capabilities", err)
+	}
+
+	return c
+}
+
+// Hints returns remediation hints for missing capabilities
+func (c *Capabilities) Hints() []string {
+	var res []string
+
+	if !c.FamilyPresent {
+		res = append(res, "The IPVS netlink family is missing. Load the kernel module using `modprobe ip_vs`.")
+	}
+	if !c.NetAdmin {
+		res = append(res, "The process lacks CAP_NET_ADMIN. Run as root or grant the capability, e.g. `setcap cap_net_admin+ep ipvsctl`.")
+	}
+	if c.Schedulers != nil && len(c.Schedulers) == 0 {
+		res = append(res, "No scheduler modules found. Install the kernel's ipvs modules (e.g. package linux-modules-extra).")
+	}
+	if c.Schedulers == nil {
+		res = append(res, "Kernel modules cannot be determined. Mount /lib/modules when running within a container.")
+	}
+	if c.Sysctls["conntrack"] == "0" && c.ConntrackMax > 0 {
+		res = append(res, "net.ipv4.vs.conntrack is 0. Set it to 1 if netfilter rules need to see ipvs connections.")
+	}
+
+	return res
+}
+
+// HasScheduler returns true if sched is available. Schedulers are assumed to
+// be available if they could not be determined.
+func (c *Capabilities) HasScheduler(sched string) bool {
+	return c.Schedulers == nil || contains(c.Schedulers, sched)
+}
+
+// HasPEName returns true if the persistence engine pe is available. Persistence
+// engines are assumed to be available if they could not be determined.
+func (c *Capabilities) HasPEName(pe string) bool {
+	return c.PENames == nil || contains(c.PENames, pe)
+}
+
+func readIntFile(fileName string) (int, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// hasNetAdmin checks the effective capabilities in a /proc/<pid>/status file
+func hasNetAdmin(statusFile string) (bool, error) {
+	f, err := os.Open(statusFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			caps, err := strconv.ParseUint(fields[1], 16, 64)
+			if err != nil {
+				return false, err
+			}
+			return caps&(1<<capNetAdmin) != 0, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("no CapEff in " + statusFile)
+}
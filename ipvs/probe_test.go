@@ -0,0 +1,109 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func writeProbeFile(t *testing.T, fileName, content string) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	sysModuleDir := t.TempDir()
+	modulesDir := t.TempDir()
+	procDir := t.TempDir()
+
+	writeProbeFile(t, filepath.Join(sysModuleDir, "ip_vs", "parameters", "conn_tab_size"), "4096\n")
+	writeProbeFile(t, filepath.Join(modulesDir, "modules.dep"), "kernel/net/netfilter/ipvs/ip_vs_rr.ko: \nkernel/net/netfilter/ipvs/ip_vs_pe_sip.ko: \n")
+	writeProbeFile(t, filepath.Join(procDir, "sys", "net", "netfilter", "nf_conntrack_max"), "262144\n")
+	writeProbeFile(t, filepath.Join(procDir, "sys", "net", "ipv4", "vs", "conntrack"), "0\n")
+	writeProbeFile(t, filepath.Join(procDir, "sys", "net", "ipv4", "vs", "sync_ports"), "1\n")
+	writeProbeFile(t, filepath.Join(procDir, "self", "status"), "Name:\tipvsctl\nCapEff:\t0000000000001000\n")
+
+	p := &prober{
+		sysModuleDir: sysModuleDir,
+		modulesDir:   modulesDir,
+		procDir:      procDir,
+		family:       func() (int, error) { return 42, nil },
+	}
+	c := p.probe()
+
+	if !c.FamilyPresent || !c.NetAdmin || c.ConnTableSize != 4096 || c.ConntrackMax != 262144 {
+		t.Errorf("unexpected capabilities %#v", c)
+	}
+	if !reflect.DeepEqual(c.Schedulers, []string{"rr"}) || !reflect.DeepEqual(c.PENames, []string{"sip"}) {
+		t.Errorf("unexpected modules %v, %v", c.Schedulers, c.PENames)
+	}
+	if c.Sysctls["conntrack"] != "0" || c.Sysctls["sync_ports"] != "1" {
+		t.Errorf("unexpected sysctls %v", c.Sysctls)
+	}
+	if len(c.Errors) != 0 {
+		t.Errorf("unexpected errors %v", c.Errors)
+	}
+	if !c.HasScheduler("rr") || c.HasScheduler("mh") || !c.HasPEName("sip") {
+		t.Error("unexpected availability")
+	}
+	if len(c.Hints()) != 1 {
+		t.Errorf("expected conntrack hint only, got %v", c.Hints())
+	}
+
+	// loaded modules without module index, e.g. within containers
+	writeProbeFile(t, filepath.Join(sysModuleDir, "ip_vs_rr", "refcnt"), "1\n")
+	p = &prober{
+		sysModuleDir: sysModuleDir,
+		modulesDir:   filepath.Join(modulesDir, "nonexisting"),
+		procDir:      procDir,
+		family:       func() (int, error) { return 42, nil },
+	}
+	c = p.probe()
+
+	if c.Schedulers != nil || c.PENames != nil {
+		t.Errorf("incomplete modules must be left undetermined, got %v, %v", c.Schedulers, c.PENames)
+	}
+	if !c.HasScheduler("wrr") || !c.HasPEName("sip") {
+		t.Error("undetermined modules must be assumed to be available")
+	}
+	if len(c.Errors) != 1 {
+		t.Errorf("expected error for modules, got %v", c.Errors)
+	}
+	if !reflect.DeepEqual(c.Hints(), []string{
+		"Kernel modules cannot be determined. Mount /lib/modules when running within a container.",
+		"net.ipv4.vs.conntrack is 0. Set it to 1 if netfilter rules need to see ipvs connections.",
+	}) {
+		t.Errorf("expected modules and conntrack hints, got %v", c.Hints())
+	}
+
+	// nothing present
+	p = &prober{
+		sysModuleDir: filepath.Join(sysModuleDir, "nonexisting"),
+		modulesDir:   filepath.Join(modulesDir, "nonexisting"),
+		procDir:      filepath.Join(procDir, "nonexisting"),
+		family:       func() (int, error) { return 0, syscall.ENOENT },
+	}
+	c = p.probe()
+
+	if c.FamilyPresent || c.NetAdmin || c.Schedulers != nil {
+		t.Errorf("unexpected capabilities %#v", c)
+	}
+	if !c.HasScheduler("mh") {
+		t.Error("undetermined schedulers must be assumed to be available")
+	}
+	if len(c.Errors) != 2 {
+		t.Errorf("expected errors for modules and capabilities, got %v", c.Errors)
+	}
+	if len(c.Hints()) != 3 {
+		t.Errorf("expected 3 hints, got %v", c.Hints())
+	}
+}
@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ModulePolicy determines how New deals with the ip_vs kernel module
+type ModulePolicy int
+
+const (
+	// ModuleAutoLoad loads the ip_vs kernel module using modprobe, if it is not loaded yet
+	ModuleAutoLoad ModulePolicy = iota
+
+	// ModuleRequireLoaded fails if the ip_vs kernel module is not loaded, without trying to load it
+	ModuleRequireLoaded
+
+	// ModuleNeverLoad neither loads nor checks the kernel module. New only fails
+	// if the kernel does not provide the IPVS netlink family.
+	ModuleNeverLoad
+)
+
+// ModuleDir is the sysfs directory present for loaded (or built-in) ip_vs modules
+const ModuleDir = "/sys/module/ip_vs"
+
+// ModuleLoadError is returned by New if the ip_vs kernel module could not be loaded
+type ModuleLoadError struct {
+	Output string // output of modprobe
+	Err    error
+}
+
+func (e *ModuleLoadError) Error() string {
+	return fmt.Sprintf("running modprobe ip_vs failed with message: `%s`, error: %v", e.Output, e.Err)
+}
+
+func (e *ModuleLoadError) Unwrap() error {
+	return e.Err
+}
+
+// ModuleNotLoadedError is returned by New if the ip_vs kernel module is required
+// to be loaded, but is not
+type ModuleNotLoadedError struct{}
+
+func (e *ModuleNotLoadedError) Error() string {
+	return "kernel module ip_vs is not loaded"
+}
+
+// FamilyNotFoundError is returned by New if the kernel does not know about the
+// IPVS generic netlink family, i.e. ipvs is not available
+type FamilyNotFoundError struct{}
+
+func (e *FamilyNotFoundError) Error() string {
+	return "IPVS generic netlink family not found. Is the ip_vs kernel module loaded?"
+}
+
+// FamilyError is returned by New if the IPVS generic netlink family could not be queried
+type FamilyError struct {
+	Err error
+}
+
+func (e *FamilyError) Error() string {
+	return fmt.Sprintf("unable to query IPVS generic netlink family: %v", e.Err)
+}
+
+func (e *FamilyError) Unwrap() error {
+	return e.Err
+}
+
+// Option configures New
+type Option func(*options)
+
+type options struct {
+	modulePolicy ModulePolicy
+}
+
+// WithModulePolicy sets the policy for dealing with the ip_vs kernel module.
+// Default is ModuleAutoLoad.
+func WithModulePolicy(p ModulePolicy) Option {
+	return func(o *options) {
+		o.modulePolicy = p
+	}
+}
+
+var ipvsSetupMu sync.Mutex
+
+// setup makes sure that the ip_vs module is available according to the
+// policy and looks up the IPVS family. Once the family is known, it is
+// not looked up again. Failures are not cached, so a later call may succeed
+// after the module has been loaded.
+func setup(o *options) error {
+	ipvsSetupMu.Lock()
+	defer ipvsSetupMu.Unlock()
+
+	if ipvsFamily != 0 {
+		return nil
+	}
+
+	switch o.modulePolicy {
+	case ModuleAutoLoad:
+		if !isModuleLoaded() {
+			if out, err := exec.Command("modprobe", "-va", "ip_vs").CombinedOutput(); err != nil {
+				return &ModuleLoadError{Output: strings.TrimSpace(string(out)), Err: err}
+			}
+		}
+	case ModuleRequireLoaded:
+		if !isModuleLoaded() {
+			return &ModuleNotLoadedError{}
+		}
+	case ModuleNeverLoad:
+	default:
+		return fmt.Errorf("unknown module policy %d", o.modulePolicy)
+	}
+
+	family, err := getIPVSFamily()
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return &FamilyNotFoundError{}
+		}
+		return &FamilyError{Err: err}
+	}
+	ipvsFamily = family
+
+	return nil
+}
+
+func isModuleLoaded() bool {
+	_, err := os.Stat(ModuleDir)
+	return err == nil
+}
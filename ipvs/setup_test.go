@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestNewRequireLoaded(t *testing.T) {
+	if isModuleLoaded() {
+		t.Skip("Skipping test that requires ip_vs not to be loaded")
+	}
+
+	_, err := New("", WithModulePolicy(ModuleRequireLoaded))
+	var notLoaded *ModuleNotLoadedError
+	if !errors.As(err, &notLoaded) {
+		t.Errorf("expected ModuleNotLoadedError, got %v", err)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	err := error(&FamilyError{Err: syscall.EPERM})
+	if !errors.Is(err, syscall.EPERM) {
+		t.Error("FamilyError must unwrap to its cause")
+	}
+
+	err = &ModuleLoadError{Output: "not found", Err: syscall.ENOENT}
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Error("ModuleLoadError must unwrap to its cause")
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/aschmidt75/ipvsctl/cmd"
 	"github.com/aschmidt75/ipvsctl/config"
@@ -21,9 +23,12 @@ func main() {
 
 	app.Version("version", version)
 
-	app.Spec = "[-v] [--params-network] [--params-env] [--params-file=<FILE>...] [--params-url=<URL>...]"
+	app.Spec = "[-v] [-o=<FORMAT>] [--netns=<PATH|NAME>] [--timeout=<DURATION>] [--params-network] [--params-env] [--params-file=<FILE>...] [--params-url=<URL>...]"
 
 	verbose := app.BoolOpt("v verbose", c.Verbose, "Show information. Default: false. False equals to being quiet")
+	output := app.StringOpt("o output", c.Output, "Output format, yaml, json or ipvsadm (get, import and convert only). Default: yaml, table for connections")
+	netns := app.StringOpt("netns", c.Netns, "Network namespace to work in, as path or name under /var/run/netns. Default: current namespace")
+	timeout := app.StringOpt("timeout", c.Timeout.String(), "Maximum duration of querying or applying the ipvs configuration, e.g. 5s. 0s for no timeout")
 	paramsHostNetwork := app.BoolOpt("params-network", c.ParamsHostNetwork, "Dynamic parameters. Add every network interface name as resolvable ip address, e.g. net.eth0")
 	paramsHostEnv := app.BoolOpt("params-env", c.ParamsHostNetwork, "Dynamic parameters. Add every environment entry, e.g. env.port=<ENV VAR \"port\">")
 	paramsFiles := make([]string, 10)
@@ -31,11 +36,17 @@ func main() {
 	paramsURLs := make([]string, 10)
 	app.StringsOptPtr(&paramsURLs, "params-url", []string{c.ParamsURLsFromEnv}, "Dynamic parameters. Add parameters from yaml or json resource given by URL.")
 
-	app.Command("get", "retrieve ipvs configuration and returns as yaml", cmd.Get)
+	app.Command("get", "retrieve ipvs configuration and returns as yaml or json", cmd.Get)
 	app.Command("apply", "apply a new configuration from file or stdin", cmd.Apply)
 	app.Command("validate", "validate a configuration from file or stdin", cmd.Validate)
 	app.Command("changeset", "compare active ipvs configuration against file or stdin and return changeset", cmd.ChangeSet)
 	app.Command("set", "change services and destinations", cmd.Set)
+	app.Command("zero", "zero counters of a single or all services", cmd.Zero)
+	app.Command("connections", "list entries of the connection table", cmd.Connections)
+	app.Command("doctor", "report ipvs capabilities of the running kernel", cmd.Doctor)
+	app.Command("import", "import rules of other tools as model", cmd.Import)
+	app.Command("convert", "convert between the model and configurations of other tools", cmd.Convert)
+	app.Command("schema", "print the JSON Schema of the model", cmd.Schema)
 
 	app.Before = func() {
 		if verbose != nil {
@@ -43,6 +54,27 @@ func main() {
 		}
 		c.SetupLogging()
 
+		if output != nil {
+			if *output != "" && *output != "yaml" && *output != "json" && *output != "ipvsadm" {
+				fmt.Fprintf(os.Stderr, "Invalid output format %s, must be yaml, json or ipvsadm\n", *output)
+				cli.Exit(2)
+			}
+			c.Output = *output
+		}
+
+		if netns != nil {
+			c.Netns = *netns
+		}
+
+		if timeout != nil {
+			d, err := time.ParseDuration(*timeout)
+			if err != nil || d < 0 {
+				fmt.Fprintf(os.Stderr, "Invalid timeout %s, must be a duration like 5s\n", *timeout)
+				cli.Exit(2)
+			}
+			c.Timeout = d
+		}
+
 		if paramsHostNetwork != nil {
 			c.ParamsHostNetwork = *paramsHostNetwork
 		}
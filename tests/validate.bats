@@ -196,3 +196,28 @@ fi
 
 	rm $TMPF
 }
+
+@test "given a model with an unknown field, when i validate it, it should fail with its position" {
+	TMPF=$(mktemp)
+
+	echo -e "services:\n  - address: tcp://1.2.3.4:80\n    destinations:\n    - address: 10.0.0.1:90\n      wieght: 1" >$TMPF
+	run $IPVSCTL validate -f $TMPF
+
+	[[ "$status" -eq 30 ]]
+	[[ "$output" =~ "line 5, column 7" ]]
+
+	rm $TMPF
+}
+
+@test "given a model with several errors, when i validate it, it should report all of them" {
+	TMPF=$(mktemp)
+
+	echo -e "services:\n  - address: tcp://1.2.3.4:80\n    sched: xrr\n    destinations:\n    - address: 10.0.0.1:90\n      weight: 89647" >$TMPF
+	run $IPVSCTL -o json validate -f $TMPF
+
+	[[ "$status" -eq 32 ]]
+	[[ "$output" =~ "services[0].sched" ]]
+	[[ "$output" =~ "services[0].destinations[0].weight" ]]
+
+	rm $TMPF
+}